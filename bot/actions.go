@@ -0,0 +1,159 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dbhq/discordgo"
+	"github.com/dbhq/starboard/bot/tables"
+)
+
+const (
+	actionPin  = "pin"
+	actionRole = "role"
+	actionDM   = "dm"
+)
+
+// maxActions is the amount of actions a guild can define
+const maxActions = 10
+
+var actionTypes = [...]string{actionPin, actionRole, actionDM}
+
+func actionKey(a *tables.Action) string {
+	return a.Type + ":" + strconv.Itoa(a.Threshold)
+}
+
+func (b *Bot) getActions(guildID string) (actions []*tables.Action, err error) {
+	key := "actions:" + guildID
+	if x, found := b.Cache.Get(key); found {
+		return x.([]*tables.Action), nil
+	}
+
+	err = b.PG.Model(&actions).Where("guild_id = ?", guildID).Order("threshold").Select()
+	if err != nil {
+		return
+	}
+
+	b.Cache.Set(key, actions, expiryTime)
+	return
+}
+
+// actionProblem checks whether the bot can perform an action, returning the locale key
+// describing what's wrong if it can't. Pins depend on the permissions in the message's
+// channel, or the guild permissions if channelID is empty. Roles always depend on the
+// guild permissions, as Manage Roles in a channel only means Manage Permissions there.
+func (b *Bot) actionProblem(s *discordgo.Session, guildID, channelID string, a *tables.Action) string {
+	switch a.Type {
+	case actionPin:
+		var perms int
+		if channelID == "" {
+			perms = guildPermissions(s, guildID, s.State.User.ID)
+		} else {
+			perms, _ = b.botPermissions(s, channelID)
+		}
+
+		if perms&discordgo.PermissionManageMessages != discordgo.PermissionManageMessages {
+			return "commands.action.problem.pin"
+		}
+	case actionRole:
+		if guildPermissions(s, guildID, s.State.User.ID)&discordgo.PermissionManageRoles != discordgo.PermissionManageRoles {
+			return "commands.action.problem.role"
+		}
+
+		role, err := s.State.Role(guildID, a.RoleID)
+		if err != nil {
+			return "commands.action.problem.unknown_role"
+		}
+
+		if role.Managed || role.Position >= highestRolePosition(s, guildID, s.State.User.ID) {
+			return "commands.action.problem.role_position"
+		}
+	}
+
+	return ""
+}
+
+// runActions performs the actions whose threshold a message reached for the first time
+func (b *Bot) runActions(s *discordgo.Session, m *tables.Message, count int) {
+	actions, err := b.getActions(m.GuildID)
+	if err != nil {
+		b.reportError(err, map[string]string{"guild": m.GuildID})
+		return
+	}
+
+	for _, a := range actions {
+		key := actionKey(a)
+		if count < a.Threshold || hasString(m.Actions, key) {
+			continue
+		}
+
+		if b.actionProblem(s, m.GuildID, m.ChannelID, a) != "" {
+			continue
+		}
+
+		// Only actions that succeeded are recorded, so failed ones are retried on the next star
+		err = b.performAction(s, m, a, count)
+		if err != nil {
+			if restErrorCode(err) != discordgo.ErrCodeCannotSendMessagesToThisUser {
+				b.reportError(err, map[string]string{"guild": m.GuildID, "action": key})
+			}
+
+			continue
+		}
+
+		m.Actions = append(m.Actions, key)
+	}
+}
+
+func (b *Bot) performAction(s *discordgo.Session, m *tables.Message, a *tables.Action, count int) (err error) {
+	switch a.Type {
+	case actionPin:
+		return s.ChannelMessagePin(m.ChannelID, m.ID)
+
+	case actionRole:
+		err = s.GuildMemberRoleAdd(m.GuildID, m.AuthorID, a.RoleID)
+		if err != nil || a.Duration == 0 {
+			return
+		}
+
+		_, err = b.PG.Model(&tables.TimedRole{
+			GuildID:   m.GuildID,
+			UserID:    m.AuthorID,
+			RoleID:    a.RoleID,
+			ExpiresAt: time.Now().Add(a.Duration),
+		}).OnConflict("(guild_id, user_id, role_id) DO UPDATE").Set("expires_at = EXCLUDED.expires_at").Insert()
+		return
+
+	case actionDM:
+		name, sentID := defaultBoard, m.SentIDs[defaultBoard]
+		if sentID == "" {
+			for name, sentID = range m.SentIDs {
+				break
+			}
+		}
+
+		channel := b.getBoardChannel(s, m, name)
+		if channel == settingNone {
+			return
+		}
+
+		guildName := m.GuildID
+		if g, err := s.State.Guild(m.GuildID); err == nil {
+			guildName = g.Name
+		}
+
+		dm, err := s.UserChannelCreate(m.AuthorID)
+		if err != nil {
+			return err
+		}
+
+		l := b.Locales.Language(b.Settings.GetString(m.GuildID, settingLanguage))
+		link := fmt.Sprintf("https://discordapp.com/channels/%s/%s/%s", m.GuildID, channel, sentID)
+
+		_, err = s.ChannelMessageSend(dm.ID, l("message.action_dm", guildName, count, link))
+		return err
+	}
+
+	return
+}
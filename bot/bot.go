@@ -40,12 +40,29 @@ const (
 	settingSaveDeletedMessages   = "save_deleted_messages"
 	settingBlockMode             = "block_mode"
 	settingRandomStarProbability = "random_star_probability"
+	settingTemplate              = "template"
+	settingWebhook               = "webhook"
+	settingMaxAge                = "max_age"
+	settingStarWindow            = "star_window"
+	settingTiers                 = "tiers"
+	settingWeight                = "weight"
+	settingMinAccountAge         = "min_account_age"
+	settingMinMemberAge          = "min_member_age"
+	settingStarBudget            = "star_budget"
+	settingRemoveOverBudget      = "remove_over_budget"
+	settingAntiStar              = "anti_star"
+	settingAntiStarRemoval       = "anti_star_removal"
+	settingMarkDeletedMessages   = "mark_deleted_messages"
+	settingFreezeContent         = "freeze_content"
+	settingSearchLanguage        = "search_language"
 
 	settingNone = "none"
 )
 
 const starEmoji = "⭐"
 
+const defaultSearchLanguage = "english"
+
 // Bot represents a starboard instance
 type Bot struct {
 	PG        *pg.DB
@@ -104,10 +121,10 @@ func New(opts *Options, pgOpts *pg.Options) (err error) {
 		settingMinimum:         1,
 		settingSelfStar:        false,
 		settingSelfStarWarning: false,
-		settingEmoji: &util.Emoji{
+		settingEmoji: []*util.Emoji{{
 			Name:    "star",
 			Unicode: starEmoji,
-		},
+		}},
 		settingChannel:               settingNone,
 		settingNSFWChannel:           settingNone,
 		settingMinimal:               false,
@@ -115,12 +132,32 @@ func New(opts *Options, pgOpts *pg.Options) (err error) {
 		settingSaveDeletedMessages:   false,
 		settingBlockMode:             "blacklist",
 		settingRandomStarProbability: float64(0),
+		settingTemplate:              "",
+		settingWebhook:               false,
+		settingMaxAge:                0,
+		settingStarWindow:            0,
+		settingTiers:                 defaultTiers,
+		settingWeight:                1,
+		settingMinAccountAge:         0,
+		settingMinMemberAge:          0,
+		settingStarBudget:            0,
+		settingRemoveOverBudget:      false,
+		settingAntiStar:              settingNone,
+		settingAntiStarRemoval:       0,
+		settingMarkDeletedMessages:   false,
+		settingFreezeContent:         false,
+		settingSearchLanguage:        defaultSearchLanguage,
 	})
 	if err != nil {
 		return
 	}
 
-	err = b.createTables((*tables.Message)(nil), (*tables.Reaction)(nil), (*tables.Block)(nil))
+	err = b.createTables((*tables.Message)(nil), (*tables.Reaction)(nil), (*tables.Block)(nil), (*tables.Board)(nil), (*tables.Action)(nil), (*tables.TimedRole)(nil), (*tables.Revision)(nil))
+	if err != nil {
+		return
+	}
+
+	err = b.migrate()
 	if err != nil {
 		return
 	}
@@ -148,6 +185,11 @@ func New(opts *Options, pgOpts *pg.Options) (err error) {
 			s.AddHandler(b.guildMemberRemove)
 		}
 
+		s.AddHandler(func(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+			tags := map[string]string{"event": "GUILD_MEMBER_UPDATE"}
+			b.reportError(b.guildMemberUpdate(s, m), tags)
+		})
+
 		s.AddHandler(func(s *discordgo.Session, m *discordgo.MessageCreate) {
 			tags := map[string]string{"event": "MESSAGE_CREATE"}
 			b.reportError(b.messageCreate(s, m), tags)
@@ -214,6 +256,9 @@ func New(opts *Options, pgOpts *pg.Options) (err error) {
 		return
 	}
 
+	go b.initReconciler(reconcileInterval)
+	go b.initScheduler(time.Minute)
+
 	b.initStatPoster(time.Minute)
 	return
 }
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	humanize "github.com/dustin/go-humanize"
 
@@ -28,7 +29,33 @@ const (
 
 const pageSize = 10
 
+// searchPageSize is the amount of search results per page, which is lower since they include snippets
+const searchPageSize = 5
+
+// searchLanguages are the text search configurations Postgres ships with
+var searchLanguages = []string{
+	"simple", "arabic", "danish", "dutch", "english", "finnish", "french", "german", "greek", "hungarian", "indonesian",
+	"irish", "italian", "lithuanian", "nepali", "norwegian", "portuguese", "romanian", "russian", "spanish", "swedish",
+	"tamil", "turkish",
+}
+
+const (
+	maxAgeDays         = 3650
+	maxStarWindowHours = 720
+	maxAccountAgeDays  = 365
+	maxMemberAgeHours  = 720
+	maxTierLabelLength = 32
+	maxEmojis          = 5
+	maxWeight          = 10
+	maxStarBudget      = 1000
+	maxAntiStarRemoval = 100
+	maxBlockReason     = 200
+)
+
 var (
+	reChannelMention  = regexp.MustCompile(`<#\d{17,19}>`)
+	reRoleMention     = regexp.MustCompile(`<@&\d{17,19}>`)
+	reMention         = regexp.MustCompile(`^<(?:@[!&]?|#)\d{17,19}>$`)
 	reMessageID       = regexp.MustCompile(`^(\d{17,19})$|https:\/\/(?:ptb\.|canary\.)discordapp\.com\/channels\/\d{17,19}\/(\d{17,19})\/(\d{17,19})`)
 	seperatorReplacer = strings.NewReplacer("_", "", "-", "")
 
@@ -38,7 +65,7 @@ var (
 	}{
 		"user":    {"@", 0},
 		"channel": {"#", 1},
-		// "role":    {"@&", 2},
+		"role":    {"@&", 2},
 	}
 )
 
@@ -101,6 +128,54 @@ func (b *Bot) registerCommands(c *commandler.Commandler) {
 			Name:      "troubleshoot",
 			GuildOnly: true,
 		},
+		{
+			Run:         b.runBoard,
+			Name:        "board",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+		},
+		{
+			Run:         b.runTemplate,
+			Name:        "template",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+		},
+		{
+			Run:       b.runAction,
+			Name:      "action",
+			GuildOnly: true,
+		},
+		{
+			Run:         b.runTop,
+			Name:        "top",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+		},
+		{
+			Run:         b.runRandom,
+			Name:        "random",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+		},
+		{
+			Run:         b.runProfile,
+			Name:        "profile",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+		},
+		{
+			Run:         b.runSearch,
+			Name:        "search",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+		},
+		{
+			Run:         b.runHistory,
+			Name:        "history",
+			GuildOnly:   true,
+			ClientPerms: discordgo.PermissionEmbedLinks,
+			MemberPerms: discordgo.PermissionManageMessages,
+		},
 	} {
 		c.AddCommand(cmd)
 	}
@@ -179,6 +254,10 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 	if len(ctx.Args) == 0 {
 		content := ""
 		for k, v := range b.Settings.GetID(ctx.GuildID) {
+			if k == settingTemplate {
+				continue
+			}
+
 			if strings.Contains(k, "channel") && v == settingNone {
 				if ch := findDefaultChannel(k, ctx.Session.State, ctx.Guild()); ch != nil {
 					v = ch.ID
@@ -206,6 +285,20 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 			}
 		}
 
+		if key == settingMinimum {
+			for _, override := range b.minimumOverrides(ctx.Guild()) {
+				str += "\n" + ctx.S("settings.phrase.override", "<#"+override.ID+">", override.Minimum)
+			}
+		}
+
+		if key == settingWeight {
+			for _, role := range ctx.Guild().Roles {
+				if v, ok := b.Settings.Lookup(role.ID, settingWeight); ok {
+					str += "\n" + ctx.S("settings.phrase.override", "<@&"+role.ID+">", v.(int))
+				}
+			}
+		}
+
 		ctx.SayRaw(ctx.S("settings."+key) + ": " + str)
 		return
 	}
@@ -251,6 +344,36 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 		ctx.Locale = b.Locales.Language(arg)
 		value = arg
 	case settingMinimum:
+		channels := ctx.MentionedChannels()
+		arg = strings.TrimSpace(reChannelMention.ReplaceAllString(arg, ""))
+
+		for _, c := range channels {
+			switch c.Type {
+			case discordgo.ChannelTypeGuildText, discordgo.ChannelTypeGuildNews, discordgo.ChannelTypeGuildForum, discordgo.ChannelTypeGuildCategory:
+			default:
+				ctx.Say("settings.restrictions.channel", l)
+				return nil
+			}
+
+			// Overrides share the settings of the guild when the channel shares its ID
+			if c.ID == ctx.GuildID {
+				ctx.Say("settings.restrictions.channel_guild", l)
+				return nil
+			}
+		}
+
+		if len(channels) != 0 && strings.ToLower(arg) == ctx.S("settings.phrase.reset") {
+			for _, c := range channels {
+				err = b.Settings.Delete(c.ID, key)
+				if err != nil {
+					return
+				}
+			}
+
+			ctx.Say("settings.phrase.updated", l)
+			return
+		}
+
 		i, err := strconv.Atoi(arg)
 		if err != nil {
 			ctx.Say("settings.restrictions.number", l)
@@ -265,8 +388,114 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 			return nil
 		}
 
+		if len(channels) != 0 {
+			for _, c := range channels {
+				err = b.Settings.Set(c.ID, key, i)
+				if err != nil {
+					return err
+				}
+			}
+
+			ctx.Say("settings.phrase.updated", l)
+			return nil
+		}
+
+		value = i
+	case settingWeight:
+		roles := ctx.MentionedRoles()
+		arg = strings.TrimSpace(reRoleMention.ReplaceAllString(arg, ""))
+
+		if len(roles) != 0 && strings.ToLower(arg) == ctx.S("settings.phrase.reset") {
+			for _, r := range roles {
+				err = b.Settings.Delete(r.ID, key)
+				if err != nil {
+					return
+				}
+			}
+
+			go b.updateRoleWeights(ctx.Session, ctx.GuildID, roles)
+
+			ctx.Say("settings.phrase.updated", l)
+			return
+		}
+
+		i, err := strconv.Atoi(arg)
+		if err != nil {
+			ctx.Say("settings.restrictions.number", l)
+			return nil
+		}
+		if i < 0 {
+			ctx.Say("settings.restrictions.min", l, 0)
+			return nil
+		}
+		if i > maxWeight {
+			ctx.Say("settings.restrictions.max", l, maxWeight)
+			return nil
+		}
+
+		if len(roles) != 0 {
+			for _, r := range roles {
+				err = b.Settings.Set(r.ID, key, i)
+				if err != nil {
+					return err
+				}
+			}
+
+			go b.updateRoleWeights(ctx.Session, ctx.GuildID, roles)
+
+			ctx.Say("settings.phrase.updated", l)
+			return nil
+		}
+
+		value = i
+	case settingStarBudget, settingAntiStarRemoval:
+		max := maxStarBudget
+		if key == settingAntiStarRemoval {
+			max = maxAntiStarRemoval
+		}
+
+		i, err := strconv.Atoi(arg)
+		if err != nil {
+			ctx.Say("settings.restrictions.number", l)
+			return nil
+		}
+		if i < 0 {
+			ctx.Say("settings.restrictions.min", l, 0)
+			return nil
+		}
+		if i > max {
+			ctx.Say("settings.restrictions.max", l, max)
+			return nil
+		}
+
+		value = i
+	case settingMaxAge, settingStarWindow, settingMinAccountAge, settingMinMemberAge:
+		max := maxAgeDays
+		switch key {
+		case settingStarWindow:
+			max = maxStarWindowHours
+		case settingMinAccountAge:
+			max = maxAccountAgeDays
+		case settingMinMemberAge:
+			max = maxMemberAgeHours
+		}
+
+		i, err := strconv.Atoi(arg)
+		if err != nil {
+			ctx.Say("settings.restrictions.number", l)
+			return nil
+		}
+		if i < 0 {
+			ctx.Say("settings.restrictions.min", l, 0)
+			return nil
+		}
+		if i > max {
+			ctx.Say("settings.restrictions.max", l, max)
+			return nil
+		}
+
 		value = i
-	case settingSelfStar, settingSelfStarWarning, settingMinimal, settingRemoveBotStars, settingSaveDeletedMessages:
+	case settingSelfStar, settingSelfStarWarning, settingMinimal, settingRemoveBotStars, settingSaveDeletedMessages, settingWebhook, settingRemoveOverBudget, settingMarkDeletedMessages, settingFreezeContent:
 		t := ctx.S("settings.phrase.true")
 		f := ctx.S("settings.phrase.false")
 		arg = strings.ToLower(arg)
@@ -277,47 +506,71 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 		}
 
 		value = arg == t
-	case settingEmoji:
+	case settingAntiStar:
+		if strings.ToLower(arg) == ctx.S("settings.phrase.reset") {
+			err = b.Settings.Delete(ctx.GuildID, key)
+			if err != nil {
+				return
+			}
+
+			ctx.Say("settings.phrase.updated", l)
+			return
+		}
+
 		e := util.ParseEmoji(arg)
 		if e == nil {
 			ctx.Say("settings.restrictions.emoji", l)
 			return
 		}
 
+		emojis, err := b.acceptedEmojis(ctx.GuildID)
+		if err != nil {
+			return err
+		}
+
+		if hasEmoji(emojis, e.Key()) {
+			ctx.Say("settings.restrictions.anti_star", l)
+			return nil
+		}
+
 		value = e
-	case settingChannel:
-		channels := ctx.MentionedChannels()
-		if len(channels) == 0 || channels[0].Type != discordgo.ChannelTypeGuildText {
-			ctx.Say("settings.restrictions.channel", l)
+	case settingEmoji:
+		emojis := parseEmojis(arg)
+		if emojis == nil {
+			ctx.Say("settings.restrictions.emoji", l)
 			return
 		}
 
-		perms, err := ctx.Session.State.UserChannelPermissions(ctx.Session.State.User.ID, channels[0].ID)
-		if err != nil || perms&discordgo.PermissionSendMessages != discordgo.PermissionSendMessages {
-			ctx.Say("settings.restrictions.channel_perms")
-			return nil
+		if len(emojis) > maxEmojis {
+			ctx.Say("settings.restrictions.max_entries", l, maxEmojis)
+			return
 		}
 
-		value = channels[0].ID
-	case settingNSFWChannel:
-		channels := ctx.MentionedChannels()
-		if len(channels) == 0 || channels[0].Type != discordgo.ChannelTypeGuildText {
-			ctx.Say("settings.restrictions.channel", l)
+		if anti := b.antiStarEmoji(ctx.GuildID); anti != nil && hasEmoji(emojis, anti.Key()) {
+			ctx.Say("settings.restrictions.anti_star", ctx.S("settings.anti_star"))
 			return
 		}
 
-		perms, err := ctx.Session.State.UserChannelPermissions(ctx.Session.State.User.ID, channels[0].ID)
-		if err != nil || perms&discordgo.PermissionSendMessages != discordgo.PermissionSendMessages {
-			ctx.Say("settings.restrictions.channel_perms")
-			return nil
+		value = emojis
+	case settingChannel:
+		ch := starboardChannelArg(ctx, l)
+		if ch == nil {
+			return
+		}
+
+		value = ch.ID
+	case settingNSFWChannel:
+		ch := starboardChannelArg(ctx, l)
+		if ch == nil {
+			return
 		}
 
-		if !channels[0].NSFW {
+		if !ch.NSFW {
 			ctx.Say("settings.restrictions.channel_nsfw")
-			return nil
+			return
 		}
 
-		value = channels[0].ID
+		value = ch.ID
 	case settingBlockMode:
 		b := ctx.S("settings.phrase.blacklist")
 		w := ctx.S("settings.phrase.whitelist")
@@ -333,6 +586,20 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 		} else {
 			value = "whitelist"
 		}
+	case settingSearchLanguage:
+		arg = strings.ToLower(arg)
+
+		if !hasString(searchLanguages, arg) {
+			ctx.SayList("settings.restrictions.one_of", l, searchLanguages...)
+			return
+		}
+
+		err = b.createSearchIndex(arg)
+		if err != nil {
+			return
+		}
+
+		value = arg
 	case settingRandomStarProbability:
 		f, err := strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
 		if err != nil {
@@ -349,6 +616,8 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 		}
 
 		value = f
+	case settingTiers:
+		return b.configTiers(ctx, l)
 	}
 
 	err = b.Settings.Set(ctx.GuildID, key, value)
@@ -356,10 +625,124 @@ func (b *Bot) runConfig(ctx *commandler.Context) (err error) {
 		return
 	}
 
+	if key == settingWeight {
+		go b.updateDefaultWeight(ctx.Session, ctx.GuildID)
+	}
+
 	ctx.Say("settings.phrase.updated", ctx.S("settings."+key))
 	return
 }
 
+// configTiers adds, removes or resets the star tiers of a guild
+func (b *Bot) configTiers(ctx *commandler.Context, l string) (err error) {
+	add := ctx.S("settings.phrase.add")
+	remove := ctx.S("settings.phrase.remove")
+	reset := ctx.S("settings.phrase.reset")
+
+	switch strings.ToLower(ctx.Args[1]) {
+	case add:
+		if len(ctx.Args) < 4 {
+			ctx.Say("settings.restrictions.tier_usage")
+			return
+		}
+
+		threshold, err := strconv.Atoi(ctx.Args[2])
+		if err != nil {
+			ctx.Say("settings.restrictions.number", l)
+			return nil
+		}
+		if threshold < 0 {
+			ctx.Say("settings.restrictions.min", l, 0)
+			return nil
+		}
+
+		color, ok := parseColor(ctx.Args[3])
+		if !ok {
+			ctx.Say("settings.restrictions.color", l)
+			return nil
+		}
+
+		tier := &util.Tier{
+			Threshold: threshold,
+			Color:     color,
+		}
+
+		label := ctx.Args[4:]
+		if len(label) != 0 {
+			if e := util.ParseEmoji(label[0]); e != nil {
+				tier.Emoji = e
+				label = label[1:]
+			}
+		}
+
+		tier.Label = strings.Join(label, " ")
+		if utf8.RuneCountInString(tier.Label) > maxTierLabelLength {
+			ctx.Say("settings.restrictions.max_length", l, maxTierLabelLength)
+			return nil
+		}
+
+		tiers := []*util.Tier{tier}
+		for _, t := range b.Settings.GetTiers(ctx.GuildID, settingTiers) {
+			if t.Threshold != threshold {
+				tiers = append(tiers, t)
+			}
+		}
+
+		if len(tiers) > maxTiers {
+			ctx.Say("settings.restrictions.max_entries", l, maxTiers)
+			return nil
+		}
+
+		sort.Slice(tiers, func(i, j int) bool {
+			return tiers[i].Threshold > tiers[j].Threshold
+		})
+
+		err = b.Settings.Set(ctx.GuildID, settingTiers, tiers)
+		if err != nil {
+			return err
+		}
+	case remove:
+		if len(ctx.Args) < 3 {
+			ctx.Say("settings.restrictions.number", l)
+			return
+		}
+
+		threshold, err := strconv.Atoi(ctx.Args[2])
+		if err != nil {
+			ctx.Say("settings.restrictions.number", l)
+			return nil
+		}
+
+		tiers := make([]*util.Tier, 0)
+		for _, t := range b.Settings.GetTiers(ctx.GuildID, settingTiers) {
+			if t.Threshold != threshold {
+				tiers = append(tiers, t)
+			}
+		}
+
+		if len(tiers) == len(b.Settings.GetTiers(ctx.GuildID, settingTiers)) {
+			ctx.Say("settings.restrictions.unknown_tier", threshold)
+			return nil
+		}
+
+		err = b.Settings.Set(ctx.GuildID, settingTiers, tiers)
+		if err != nil {
+			return err
+		}
+	case reset:
+		err = b.Settings.Delete(ctx.GuildID, settingTiers)
+		if err != nil {
+			return
+		}
+	default:
+		ctx.SayList("settings.restrictions.one_of", l, add, remove, reset)
+		return
+	}
+
+	ctx.Say("settings.phrase.updated", l)
+	return
+}
+
 func (b *Bot) runSetup(ctx *commandler.Context) (err error) {
 	arg := strings.ToLower(strings.Join(ctx.Args, " "))
 	nsfw := strings.Contains(arg, ctx.S("commands.setup.nsfw"))
@@ -392,7 +775,7 @@ func (b *Bot) runSetup(ctx *commandler.Context) (err error) {
 		var count int
 
 		for _, c := range g.Channels {
-			if c.Type != discordgo.ChannelTypeGuildText {
+			if !isStarboardType(c) {
 				continue
 			}
 
@@ -408,11 +791,17 @@ func (b *Bot) runSetup(ctx *commandler.Context) (err error) {
 		}
 	}
 
+	// The new channel goes into the current channel's category, or into the parent's for threads
+	parent := ctx.Channel().ParentID
+	if c, err := b.sourceChannel(ctx.Session, ctx.ChannelID); err == nil {
+		parent = c.ParentID
+	}
+
 	ch, err := ctx.Session.GuildChannelCreateComplex(ctx.GuildID, discordgo.GuildChannelCreateData{
 		Type:     discordgo.ChannelTypeGuildText,
 		Name:     name,
 		NSFW:     nsfw,
-		ParentID: ctx.Channel().ParentID,
+		ParentID: parent,
 		PermissionOverwrites: []*discordgo.PermissionOverwrite{
 			{
 				ID:   ctx.GuildID,
@@ -535,31 +924,59 @@ func (b *Bot) runBlock(ctx *commandler.Context) (err error) {
 			return nil
 		}
 
-		blocks := make([]tables.Block, 0)
+		// Anything following the mentions is an optional duration and then the reason
+		var rest []string
+		for i, arg := range ctx.Args[1:] {
+			if !reMention.MatchString(arg) {
+				rest = ctx.Args[i+1:]
+				break
+			}
+		}
 
-		for _, m := range ctx.Mentions {
+		var expiresAt time.Time
+		var reason string
+
+		if action == add && len(rest) != 0 {
+			d, err := util.ParseDuration(rest[0])
+			switch err {
+			case nil:
+				expiresAt = time.Now().Add(d)
+				rest = rest[1:]
+			case util.ErrDurationTooLong:
+				ctx.Say("commands.block.phrase.duration_max", util.FormatDuration(util.MaxDuration))
+				return nil
+			}
+
+			reason = strings.Join(rest, " ")
+			if len([]rune(reason)) > maxBlockReason {
+				ctx.Say("commands.block.phrase.reason", maxBlockReason)
+				return nil
+			}
+		}
+
+		blocks := make([]tables.Block, 0)
+		block := func(id, typ string) {
 			blocks = append(blocks, tables.Block{
-				ID:      m.ID,
-				GuildID: ctx.GuildID,
-				Type:    "user",
+				ID:          id,
+				GuildID:     ctx.GuildID,
+				Type:        typ,
+				ModeratorID: ctx.Author.ID,
+				Reason:      reason,
+				ExpiresAt:   expiresAt,
 			})
 		}
 
+		for _, m := range ctx.Mentions {
+			block(m.ID, "user")
+		}
+
 		for _, c := range ctx.MentionedChannels() {
-			blocks = append(blocks, tables.Block{
-				ID:      c.ID,
-				GuildID: ctx.GuildID,
-				Type:    "channel",
-			})
+			block(c.ID, "channel")
 		}
 
-		// for _, r := range ctx.MentionedRoles() {
-		// 	blocks = append(blocks, tables.Block{
-		// 		ID:      r.ID,
-		// 		GuildID: ctx.GuildID,
-		// 		Type:    "role",
-		// 	})
-		// }
+		for _, r := range ctx.MentionedRoles() {
+			block(r.ID, "role")
+		}
 
 		if len(blocks) == 0 && (action != remove || ctx.Args[1] != all) {
 			ctx.Say("commands.block.phrase.missing")
@@ -567,7 +984,10 @@ func (b *Bot) runBlock(ctx *commandler.Context) (err error) {
 		}
 
 		if action == add {
-			_, err = b.PG.Model(&blocks).OnConflict("DO NOTHING").Insert()
+			_, err = b.PG.Model(&blocks).
+				OnConflict("(id, guild_id) DO UPDATE").
+				Set("type = EXCLUDED.type, moderator_id = EXCLUDED.moderator_id, reason = EXCLUDED.reason, expires_at = EXCLUDED.expires_at").
+				Insert()
 		} else {
 			q := b.PG.Model((*tables.Block)(nil)).Where("guild_id = ?", ctx.GuildID)
 
@@ -609,31 +1029,46 @@ func (b *Bot) runBlock(ctx *commandler.Context) (err error) {
 				Name:  ctx.S("commands.block.phrase.channels"),
 				Value: none,
 			},
-			// {
-			// 	Name:  ctx.S("commands.block.phrase.roles"),
-			// 	Value: none,
-			// },
+			{
+				Name:  ctx.S("commands.block.phrase.roles"),
+				Value: none,
+			},
 		},
 	}
 
 	var blocks []tables.Block
-	err = b.PG.Model(&blocks).Where("guild_id = ?", ctx.GuildID).Select()
+	err = b.PG.Model(&blocks).
+		Where("guild_id = ?", ctx.GuildID).
+		Where("expires_at IS NULL OR expires_at > now()").
+		Select()
 	if err != nil && err != pg.ErrNoRows {
 		return
 	}
 
 	for _, b := range blocks {
 		info := typeToInfo[b.Type]
-		text := "<" + info.Identifier + b.ID + ">\n"
+		text := "<" + info.Identifier + b.ID + ">"
 		field := embed.Fields[info.Index]
 
+		if b.Reason != "" {
+			text += " — " + b.Reason
+		}
+
+		if !b.ExpiresAt.IsZero() {
+			text += " " + ctx.S("commands.block.phrase.expires", humanize.Time(b.ExpiresAt))
+		}
+
 		if field.Value == none {
-			field.Value = text
+			field.Value = text + "\n"
 		} else {
-			field.Value += text
+			field.Value += text + "\n"
 		}
 	}
 
+	for _, field := range embed.Fields {
+		field.Value = truncate(field.Value, 1024)
+	}
+
 	ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, embed)
 	return
 }
@@ -644,28 +1079,17 @@ func (b *Bot) runFix(ctx *commandler.Context) (err error) {
 		return
 	}
 
-	matches := reMessageID.FindStringSubmatch(ctx.Args[0])
-	if matches == nil {
+	channelID, messageID, ok := parseMessageArg(ctx, ctx.Args[0])
+	if !ok {
 		ctx.Say("commands.fix.phrase.id")
 		return
 	}
 
-	channelID := matches[2]
-	messageID := matches[3]
+	required := discordgo.PermissionReadMessages | discordgo.PermissionReadMessageHistory
 
-	if channelID == "" {
-		channelID = ctx.ChannelID
-	}
-
-	if messageID == "" {
-		messageID = matches[1]
-	}
-
-	required := discordgo.PermissionReadMessages | discordgo.PermissionReadMessageHistory
-
-	perms, err := ctx.Session.State.UserChannelPermissions(ctx.Author.ID, channelID)
-	if err != nil || perms&required != required {
-		ctx.Say("commands.fix.phrase.permissions")
+	perms, err := ctx.Session.State.UserChannelPermissions(ctx.Author.ID, channelID)
+	if err != nil || perms&required != required {
+		ctx.Say("commands.fix.phrase.permissions")
 	}
 
 	err = b.updateMessage(ctx.Session, &tables.Message{
@@ -682,7 +1106,28 @@ func (b *Bot) runFix(ctx *commandler.Context) (err error) {
 		return
 	}
 
-	ctx.Say("commands.fix.phrase.done")
+	// Whether self-stars count depends on the author
+	msg, err := b.getMessage(ctx.Session, messageID, channelID)
+	if err != nil {
+		return
+	}
+
+	young, fresh, err := b.ignoredStars(msg)
+	if err != nil {
+		return
+	}
+
+	content := ctx.S("commands.fix.phrase.done")
+
+	if young != 0 {
+		content += "\n" + ctx.S("commands.fix.phrase.ignored_account_age", young, b.Settings.GetInt(ctx.GuildID, settingMinAccountAge))
+	}
+
+	if fresh != 0 {
+		content += "\n" + ctx.S("commands.fix.phrase.ignored_member_age", fresh, b.Settings.GetInt(ctx.GuildID, settingMinMemberAge))
+	}
+
+	ctx.SayRaw(content)
 	return
 }
 
@@ -697,86 +1142,89 @@ func (b *Bot) runReloadLocales(ctx *commandler.Context) (err error) {
 }
 
 func (b *Bot) runLeaderboard(ctx *commandler.Context) (err error) {
-	var dataTotal struct {
-		Count int
-	}
-	_, err = b.PG.Query(&dataTotal, `
-	SELECT COUNT(*) AS count FROM (
-		SELECT DISTINCT author_id
-		FROM messages
-		WHERE guild_id = (?)
-	) AS messages
-	`, ctx.GuildID)
-
-	if dataTotal.Count == 0 {
-		ctx.Say("commands.leaderboard.phrase.empty")
-		return
-	}
+	givers := false
+	page := 0
+	var rest []string
 
-	max := int(math.Ceil(float64(dataTotal.Count) / float64(pageSize)))
-	offset := 0
-	if len(ctx.Args) != 0 {
-		i, err := strconv.Atoi(ctx.Args[0])
-		if err != nil {
-			ctx.Say("commands.leaderboard.phrase.number")
-			return nil
+	for _, arg := range ctx.Args {
+		if strings.ToLower(arg) == ctx.S("commands.leaderboard.phrase.givers") {
+			givers = true
+			continue
 		}
 
-		if i < 1 {
-			ctx.Say("commands.leaderboard.phrase.min", 1)
-			return nil
-		}
+		if i, err := strconv.Atoi(arg); err == nil {
+			if i < 1 {
+				ctx.Say("commands.leaderboard.phrase.min", 1)
+				return nil
+			}
 
-		if i > max {
-			ctx.Say("commands.leaderboard.phrase.max", max)
-			return nil
+			page = i
+			continue
 		}
 
-		offset = i - 1
+		rest = append(rest, arg)
+	}
+
+	filter, ok := parseStarFilter(ctx, rest)
+	if !ok {
+		return
 	}
 
-	extraWhere := ""
+	// Givers are ranked by the stars they gave, everyone else by the stars they received
+	column := "author_id"
+	title := ctx.S("commands.leaderboard.phrase.title")
+	if givers {
+		column = "user_id"
+		title = ctx.S("commands.leaderboard.phrase.title_givers")
+	}
 
-	if !b.Settings.GetBool(ctx.GuildID, settingSelfStar) {
-		extraWhere += "WHERE messages.author_id != reactions.user_id"
+	var total int
+	_, err = b.PG.QueryOne(pg.Scan(&total), "SELECT COUNT(DISTINCT "+column+") FROM (?) AS given", filter.apply(b.guildStars(ctx.GuildID)))
+	if err != nil {
+		return
 	}
 
-	if b.Settings.GetBool(ctx.GuildID, settingRemoveBotStars) {
-		if extraWhere != "" {
-			extraWhere += " AND reactions.bot = FALSE"
-		}
+	if total == 0 {
+		ctx.Say("commands.leaderboard.phrase.empty")
+		return
+	}
+
+	max := int(math.Ceil(float64(total) / float64(pageSize)))
+	if page > max {
+		ctx.Say("commands.leaderboard.phrase.max", max)
+		return nil
+	}
+
+	offset := 0
+	if page != 0 {
+		offset = page - 1
 	}
 
 	var data []struct {
-		AuthorID   string
+		UserID     string
 		TotalStars int
 	}
 	_, err = b.PG.Query(&data, `
-	SELECT SUM(star_count) AS total_stars, author_id FROM (
-		SELECT COUNT(*) AS star_count, author_id, message_id, guild_id FROM reactions
-		JOIN messages ON messages.id = reactions.message_id
-		`+extraWhere+`
-		GROUP BY author_id, message_id, guild_id
-	) AS messages
-	WHERE guild_id = (?)
-	GROUP BY messages.author_id
-	ORDER BY total_stars DESC
+	SELECT `+column+` AS user_id, SUM(weight) AS total_stars FROM (?) AS given
+	GROUP BY `+column+`
+	ORDER BY total_stars DESC, `+column+`
 	OFFSET (?)
 	LIMIT (?)
-	`, ctx.GuildID, offset*pageSize, pageSize)
+	`, filter.apply(b.guildStars(ctx.GuildID)), offset*pageSize, pageSize)
 	if err != nil {
 		return
 	}
 
 	embed := &discordgo.MessageEmbed{
 		Color: gray,
+		Title: title,
 		Fields: []*discordgo.MessageEmbedField{
 			{
-				Name:   "User",
+				Name:   ctx.S("commands.leaderboard.phrase.user"),
 				Inline: true,
 			},
 			{
-				Name:   "Stars",
+				Name:   ctx.S("commands.leaderboard.phrase.stars"),
 				Inline: true,
 			},
 		},
@@ -785,8 +1233,12 @@ func (b *Bot) runLeaderboard(ctx *commandler.Context) (err error) {
 		},
 	}
 
+	if filter.ChannelID != "" {
+		embed.Description = ctx.S("commands.leaderboard.phrase.channel", filter.ChannelID)
+	}
+
 	for i, row := range data {
-		embed.Fields[0].Value += strconv.Itoa((offset*pageSize)+i+1) + ". <@" + row.AuthorID + ">\n"
+		embed.Fields[0].Value += strconv.Itoa((offset*pageSize)+i+1) + ". <@" + row.UserID + ">\n"
 		embed.Fields[1].Value += strconv.Itoa(row.TotalStars) + "\n"
 	}
 
@@ -794,8 +1246,381 @@ func (b *Bot) runLeaderboard(ctx *commandler.Context) (err error) {
 	return
 }
 
+func (b *Bot) runTop(ctx *commandler.Context) (err error) {
+	filter, ok := parseStarFilter(ctx, ctx.Args)
+	if !ok {
+		return
+	}
+
+	q, err := b.excludeNSFW(ctx, filter.apply(b.guildStars(ctx.GuildID)))
+	if err != nil {
+		return
+	}
+
+	var rows []struct {
+		MessageID string
+		Stars     int
+	}
+
+	_, err = b.PG.Query(&rows, `
+	SELECT message_id, SUM(weight) AS stars FROM (?) AS given
+	GROUP BY message_id
+	ORDER BY stars DESC, message_id DESC
+	LIMIT ?
+	`, q, pageSize)
+	if err != nil {
+		return
+	}
+
+	if len(rows) == 0 {
+		ctx.Say("commands.top.phrase.empty")
+		return
+	}
+
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.MessageID
+	}
+
+	var msgs []*tables.Message
+	err = b.PG.Model(&msgs).Where("id IN (?)", pg.In(ids)).Select()
+	if err != nil {
+		return
+	}
+
+	byID := make(map[string]*tables.Message, len(msgs))
+	for _, msg := range msgs {
+		byID[msg.ID] = msg
+	}
+
+	emoji := b.Settings.GetEmoji(ctx.GuildID, settingEmoji).String()
+	var sb strings.Builder
+
+	for i, row := range rows {
+		msg, ok := byID[row.MessageID]
+		if !ok {
+			continue
+		}
+
+		sb.WriteString(ctx.S("commands.top.phrase.entry", i+1, emoji, row.Stars, msg.AuthorID, msg.ChannelID, messageLink(msg)) + "\n")
+
+		if content := strings.Join(strings.Fields(msg.Content), " "); content != "" {
+			sb.WriteString("> " + truncate(content, maxReplyLength) + "\n")
+		}
+	}
+
+	ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, &discordgo.MessageEmbed{
+		Color:       gray,
+		Title:       ctx.S("commands.top.phrase.title"),
+		Description: truncate(sb.String(), 2048),
+	})
+	return
+}
+
+func (b *Bot) runRandom(ctx *commandler.Context) (err error) {
+	var filter starFilter
+	if u := mentionedUser(ctx); u != nil {
+		filter.AuthorID = u.ID
+	}
+
+	if channels := ctx.MentionedChannels(); len(channels) != 0 {
+		filter.ChannelID = channels[0].ID
+	}
+
+	minimum := 0
+	for _, arg := range ctx.Args {
+		if reMention.MatchString(arg) {
+			continue
+		}
+
+		i, err := strconv.Atoi(arg)
+		if err != nil || i < 0 {
+			ctx.Say("commands.random.phrase.invalid", arg)
+			return nil
+		}
+
+		minimum = i
+	}
+
+	msg := &tables.Message{}
+	q := filter.apply(b.PG.Model(msg).Where("message.guild_id = ?", ctx.GuildID))
+
+	q, err = b.excludeNSFW(ctx, q)
+	if err != nil {
+		return
+	}
+
+	if minimum != 0 {
+		q = q.Where("message.id IN (SELECT message_id FROM (?) AS given GROUP BY message_id HAVING SUM(weight) >= ?)", b.guildStars(ctx.GuildID), minimum)
+	}
+
+	err = q.OrderExpr("random()").Limit(1).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			ctx.Say("commands.random.phrase.empty")
+			return nil
+		}
+
+		return
+	}
+
+	boards, err := b.getStarboards(ctx.Session, msg.ChannelID, msg.GuildID)
+	if err != nil {
+		return
+	}
+
+	board := &tables.Board{
+		Name:  defaultBoard,
+		Emoji: b.Settings.GetEmoji(ctx.GuildID, settingEmoji),
+	}
+	if len(boards) != 0 {
+		board = boards[0]
+	}
+
+	count, err := b.countStars(msg, board)
+	if err != nil {
+		return
+	}
+
+	anti, err := b.countAntiStars(msg)
+	if err != nil {
+		return
+	}
+
+	_, err = ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, b.generateEmbed(msg, board, count-anti, anti))
+	return
+}
+
+func (b *Bot) runProfile(ctx *commandler.Context) (err error) {
+	user := ctx.Author
+	if u := mentionedUser(ctx); u != nil {
+		user = u
+	}
+
+	var received, given, entries, rank int
+
+	_, err = b.PG.QueryOne(pg.Scan(&received), "SELECT COALESCE(SUM(weight), 0) FROM (?) AS given",
+		b.guildStars(ctx.GuildID).Where("message.author_id = ?", user.ID))
+	if err != nil {
+		return
+	}
+
+	_, err = b.PG.QueryOne(pg.Scan(&given), "SELECT COALESCE(SUM(weight), 0) FROM (?) AS given",
+		b.guildStars(ctx.GuildID).Where("reaction.user_id = ?", user.ID))
+	if err != nil {
+		return
+	}
+
+	entries, err = b.PG.Model((*tables.Message)(nil)).
+		Where("guild_id = ?", ctx.GuildID).
+		Where("author_id = ?", user.ID).
+		Count()
+	if err != nil {
+		return
+	}
+
+	// Ranks follow the leaderboard, so members who received no stars have none
+	_, err = b.PG.Query(pg.Scan(&rank), `
+	SELECT rank FROM (
+		SELECT author_id, RANK() OVER (ORDER BY SUM(weight) DESC) AS rank FROM (?) AS given
+		GROUP BY author_id
+	) AS ranks
+	WHERE author_id = ?
+	`, b.guildStars(ctx.GuildID), user.ID)
+	if err != nil {
+		return
+	}
+
+	var best struct {
+		MessageID string
+		ChannelID string
+		Stars     int
+	}
+
+	_, err = b.PG.Query(&best, `
+	SELECT message_id, channel_id, SUM(weight) AS stars FROM (?) AS given
+	GROUP BY message_id, channel_id
+	ORDER BY stars DESC, message_id DESC
+	LIMIT 1
+	`, b.guildStars(ctx.GuildID).Where("message.author_id = ?", user.ID))
+	if err != nil {
+		return
+	}
+
+	var favourite string
+
+	_, err = b.PG.Query(pg.Scan(&favourite), `
+	SELECT channel_id FROM (?) AS given
+	GROUP BY channel_id
+	ORDER BY SUM(weight) DESC, channel_id
+	LIMIT 1
+	`, b.guildStars(ctx.GuildID).Where("message.author_id = ?", user.ID))
+	if err != nil {
+		return
+	}
+
+	none := ctx.S("commands.profile.phrase.none")
+	embed := &discordgo.MessageEmbed{
+		Color: gray,
+		Author: &discordgo.MessageEmbedAuthor{
+			Name:    user.Username,
+			IconURL: user.AvatarURL(""),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{
+				Name:   ctx.S("commands.profile.phrase.received"),
+				Value:  humanize.Comma(int64(received)),
+				Inline: true,
+			},
+			{
+				Name:   ctx.S("commands.profile.phrase.given"),
+				Value:  humanize.Comma(int64(given)),
+				Inline: true,
+			},
+			{
+				Name:   ctx.S("commands.profile.phrase.entries"),
+				Value:  humanize.Comma(int64(entries)),
+				Inline: true,
+			},
+			{
+				Name:   ctx.S("commands.profile.phrase.rank"),
+				Value:  none,
+				Inline: true,
+			},
+			{
+				Name:   ctx.S("commands.profile.phrase.channel"),
+				Value:  none,
+				Inline: true,
+			},
+			{
+				Name:   ctx.S("commands.profile.phrase.best"),
+				Value:  none,
+				Inline: true,
+			},
+		},
+	}
+
+	if rank != 0 {
+		embed.Fields[3].Value = "#" + strconv.Itoa(rank)
+	}
+
+	if favourite != "" {
+		embed.Fields[4].Value = "<#" + favourite + ">"
+	}
+
+	if best.MessageID != "" {
+		link := messageLink(&tables.Message{ID: best.MessageID, ChannelID: best.ChannelID, GuildID: ctx.GuildID})
+		embed.Fields[5].Value = ctx.S("commands.profile.phrase.best_value", best.Stars, link)
+	}
+
+	_, err = ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, embed)
+	return
+}
+
+func (b *Bot) runSearch(ctx *commandler.Context) (err error) {
+	var filter starFilter
+	if u := mentionedUser(ctx); u != nil {
+		filter.AuthorID = u.ID
+	}
+
+	if channels := ctx.MentionedChannels(); len(channels) != 0 {
+		filter.ChannelID = channels[0].ID
+	}
+
+	var terms []string
+	for _, arg := range ctx.Args {
+		if !reMention.MatchString(arg) {
+			terms = append(terms, arg)
+		}
+	}
+
+	// A trailing number picks the page, as long as something is left to search for
+	page := 1
+	if len(terms) > 1 {
+		if i, err := strconv.Atoi(terms[len(terms)-1]); err == nil {
+			page = i
+			terms = terms[:len(terms)-1]
+		}
+	}
+
+	if len(terms) == 0 {
+		ctx.Say("commands.search.phrase.missing")
+		return
+	}
+
+	if page < 1 {
+		ctx.Say("commands.leaderboard.phrase.min", 1)
+		return
+	}
+
+	lang := b.Settings.GetString(ctx.GuildID, settingSearchLanguage)
+	query := strings.Join(terms, " ")
+
+	q := filter.apply(b.PG.Model((*tables.Message)(nil)).
+		Where("message.guild_id = ?", ctx.GuildID).
+		Where("to_tsvector(?::regconfig, message.content) @@ plainto_tsquery(?::regconfig, ?)", lang, lang, query))
+
+	q, err = b.excludeNSFW(ctx, q)
+	if err != nil {
+		return
+	}
+
+	total, err := q.Count()
+	if err != nil {
+		return
+	}
+
+	if total == 0 {
+		ctx.Say("commands.search.phrase.empty")
+		return
+	}
+
+	max := int(math.Ceil(float64(total) / float64(searchPageSize)))
+	if page > max {
+		ctx.Say("commands.leaderboard.phrase.max", max)
+		return
+	}
+
+	var results []struct {
+		ID        string
+		AuthorID  string
+		ChannelID string
+		Snippet   string
+	}
+
+	err = q.
+		ColumnExpr("message.id, message.author_id, message.channel_id").
+		ColumnExpr("ts_headline(?::regconfig, message.content, plainto_tsquery(?::regconfig, ?), 'StartSel=**, StopSel=**, MaxWords=25, MinWords=10') AS snippet", lang, lang, query).
+		OrderExpr("ts_rank(to_tsvector(?::regconfig, message.content), plainto_tsquery(?::regconfig, ?)) DESC", lang, lang, query).
+		OrderExpr("message.id::bigint DESC").
+		Offset((page - 1) * searchPageSize).
+		Limit(searchPageSize).
+		Select(&results)
+	if err != nil {
+		return
+	}
+
+	var sb strings.Builder
+
+	for i, r := range results {
+		link := messageLink(&tables.Message{ID: r.ID, ChannelID: r.ChannelID, GuildID: ctx.GuildID})
+		sb.WriteString(ctx.S("commands.search.phrase.result", (page-1)*searchPageSize+i+1, r.AuthorID, r.ChannelID, link) + "\n")
+		sb.WriteString("> " + truncate(strings.Join(strings.Fields(r.Snippet), " "), maxReplyLength*2) + "\n")
+	}
+
+	ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, &discordgo.MessageEmbed{
+		Color:       gray,
+		Title:       truncate(ctx.S("commands.search.phrase.title", query), 256),
+		Description: truncate(sb.String(), 2048),
+		Footer: &discordgo.MessageEmbedFooter{
+			Text: ctx.S("commands.leaderboard.phrase.page", page, max),
+		},
+	})
+	return
+}
+
 func (b *Bot) runTroubleshoot(ctx *commandler.Context) (err error) {
-	var errors, warnings []string
+	var errors, warnings, notes []string
 
 	channelData := [...]string{settingChannel, settingNSFWChannel}
 
@@ -822,7 +1647,7 @@ func (b *Bot) runTroubleshoot(ctx *commandler.Context) (err error) {
 		var nsfwChannels, channels int
 
 		for _, c := range ctx.Guild().Channels {
-			if c.Type == discordgo.ChannelTypeGuildText {
+			if isStarboardType(c) || c.Type == discordgo.ChannelTypeGuildForum {
 				if c.NSFW {
 					nsfwChannels++
 				} else {
@@ -866,39 +1691,667 @@ func (b *Bot) runTroubleshoot(ctx *commandler.Context) (err error) {
 				errors = append(errors, ctx.S("commands.troubleshoot.missing_permissions", ctx.S("permissions."+util.Permissions[perm]), mention))
 			}
 		}
+
+		if b.Settings.GetBool(ctx.GuildID, settingWebhook) && perms&discordgo.PermissionManageWebhooks != discordgo.PermissionManageWebhooks {
+			warnings = append(warnings, ctx.S("commands.troubleshoot.missing_webhook_permissions", ctx.S("permissions.MANAGE_WEBHOOKS"), mention))
+		}
 	}
 
-	if len(errors) == 0 && len(warnings) == 0 {
-		ctx.Say("commands.troubleshoot.passed", ctx.Prefix, ctx.S("commands.invite.name"))
-	} else {
-		var final strings.Builder
+	for _, override := range b.minimumOverrides(ctx.Guild()) {
+		notes = append(notes, ctx.S("commands.troubleshoot.minimum_override", "<#"+override.ID+">", override.Minimum))
+	}
+
+	actions, err := b.getActions(ctx.GuildID)
+	if err != nil {
+		return
+	}
+
+	for _, a := range actions {
+		if problem := b.actionProblem(ctx.Session, ctx.GuildID, "", a); problem != "" {
+			warnings = append(warnings, ctx.S("commands.troubleshoot.action", describeAction(ctx, a), ctx.S(problem)))
+		} else {
+			notes = append(notes, describeAction(ctx, a))
+		}
+	}
+
+	var final strings.Builder
 
-		if len(errors) != 0 {
-			final.WriteString(ctx.S("commands.troubleshoot.errors"))
+	if len(errors) == 0 && len(warnings) == 0 {
+		final.WriteString(ctx.S("commands.troubleshoot.passed", ctx.Prefix, ctx.S("commands.invite.name")))
+		final.WriteString("\n\n")
+	}
+
+	for _, section := range [...]struct {
+		title string
+		lines []string
+	}{
+		{"commands.troubleshoot.errors", errors},
+		{"commands.troubleshoot.warnings", warnings},
+		{"commands.troubleshoot.notes", notes},
+	} {
+		if len(section.lines) == 0 {
+			continue
+		}
+
+		final.WriteString(ctx.S(section.title))
+		final.WriteByte('\n')
+
+		for _, line := range section.lines {
+			final.WriteString(line)
 			final.WriteByte('\n')
+		}
 
-			for _, e := range errors {
-				final.WriteString(e)
-				final.WriteByte('\n')
+		final.WriteByte('\n')
+	}
+
+	ctx.SayRaw(final.String())
+	return
+}
+
+func (b *Bot) runBoard(ctx *commandler.Context) (err error) {
+	if len(ctx.Args) != 0 {
+		memberPerms, err := ctx.Session.State.UserChannelPermissions(ctx.Author.ID, ctx.ChannelID)
+		if err != nil {
+			ctx.Say("restrictions.permissions.missing.member.error")
+			return err
+		}
+
+		if memberPerms&discordgo.PermissionManageMessages == 0 {
+			ctx.Say("restrictions.permissions.missing.member", "```diff\n- "+ctx.S("permissions.MANAGE_MESSAGES")+"\n```")
+			return nil
+		}
+
+		action := strings.ToLower(ctx.Args[0])
+		add := ctx.S("commands.board.phrase.add")
+		remove := ctx.S("commands.board.phrase.remove")
+		edit := ctx.S("commands.board.phrase.edit")
+
+		if action != add && action != remove && action != edit {
+			ctx.SayList("settings.restrictions.one_of", ctx.S("commands.block.phrase.action"), add, remove, edit)
+			return nil
+		}
+
+		if len(ctx.Args) == 1 {
+			ctx.Say("commands.board.phrase.missing")
+			return nil
+		}
+
+		name := strings.ToLower(ctx.Args[1])
+		if name == defaultBoard || len(name) > 32 {
+			ctx.Say("commands.board.phrase.invalid_name", defaultBoard, 32)
+			return nil
+		}
+
+		board := &tables.Board{Name: name, GuildID: ctx.GuildID}
+
+		switch action {
+		case add:
+			ch := starboardChannelArg(ctx, ctx.S("settings.channel"))
+			if ch == nil {
+				return nil
 			}
 
-			final.WriteByte('\n')
+			board.ChannelID = ch.ID
+			emojis := b.Settings.GetEmojis(ctx.GuildID, settingEmoji)
+			board.Emoji = emojis[0]
+			board.Emojis = emojis[1:]
+			board.Minimum = b.Settings.GetInt(ctx.GuildID, settingMinimum)
+			board.NSFW = ch.NSFW
+
+			res, err := b.PG.Model(board).OnConflict("DO NOTHING").Insert()
+			if err != nil {
+				return err
+			}
+
+			if res.RowsAffected() == 0 {
+				ctx.Say("commands.board.phrase.exists", name)
+				return nil
+			}
+		case remove:
+			res, err := b.PG.Model(board).WherePK().Delete()
+			if err != nil && err != pg.ErrNoRows {
+				return err
+			}
+
+			if err == pg.ErrNoRows || res.RowsAffected() == 0 {
+				ctx.Say("commands.board.phrase.unknown", name)
+				return nil
+			}
+		case edit:
+			err = b.PG.Select(board)
+			if err != nil {
+				if err == pg.ErrNoRows {
+					ctx.Say("commands.board.phrase.unknown", name)
+					return nil
+				}
+
+				return err
+			}
+
+			if len(ctx.Args) < 4 {
+				ctx.Say("commands.board.phrase.missing_value")
+				return nil
+			}
+
+			key := ctx.Locale("commands.board.to_key." + seperatorReplacer.Replace(strings.ToLower(ctx.Args[2])))
+			if key == "" {
+				ctx.Say("commands.board.phrase.unknown_property")
+				return nil
+			}
+
+			arg := strings.Join(ctx.Args[3:], " ")
+			l := ctx.S("commands.board.property." + key)
+
+			switch key {
+			case "channel":
+				ch := starboardChannelArg(ctx, l)
+				if ch == nil {
+					return nil
+				}
+
+				if board.NSFW && !ch.NSFW {
+					ctx.Say("settings.restrictions.channel_nsfw")
+					return nil
+				}
+
+				board.ChannelID = ch.ID
+			case "emoji":
+				emojis := parseEmojis(arg)
+				if emojis == nil {
+					ctx.Say("settings.restrictions.emoji", l)
+					return nil
+				}
+
+				if len(emojis) > maxEmojis {
+					ctx.Say("settings.restrictions.max_entries", l, maxEmojis)
+					return nil
+				}
+
+				if anti := b.antiStarEmoji(ctx.GuildID); anti != nil && hasEmoji(emojis, anti.Key()) {
+					ctx.Say("settings.restrictions.anti_star", ctx.S("settings.anti_star"))
+					return nil
+				}
+
+				board.Emoji = emojis[0]
+				board.Emojis = emojis[1:]
+			case "minimum":
+				i, err := strconv.Atoi(arg)
+				if err != nil {
+					ctx.Say("settings.restrictions.number", l)
+					return nil
+				}
+				if i < 1 {
+					ctx.Say("settings.restrictions.min", l, 1)
+					return nil
+				}
+				if i > 100 {
+					ctx.Say("settings.restrictions.max", l, 100)
+					return nil
+				}
+
+				board.Minimum = i
+			case "nsfw":
+				t := ctx.S("settings.phrase.true")
+				f := ctx.S("settings.phrase.false")
+				arg = strings.ToLower(arg)
+
+				if arg != t && arg != f {
+					ctx.SayList("settings.restrictions.one_of", l, t, f)
+					return nil
+				}
+
+				if arg == t {
+					if ch, err := ctx.Session.State.Channel(board.ChannelID); err != nil || !ch.NSFW {
+						ctx.Say("settings.restrictions.channel_nsfw")
+						return nil
+					}
+				}
+
+				board.NSFW = arg == t
+			case "channels":
+				board.Channels = nil
+
+				if strings.ToLower(arg) != ctx.S("commands.block.phrase.all") {
+					for _, c := range ctx.MentionedChannels() {
+						board.Channels = append(board.Channels, c.ID)
+					}
+
+					if len(board.Channels) == 0 {
+						ctx.Say("settings.restrictions.channel", l)
+						return nil
+					}
+				}
+			}
+
+			_, err = b.PG.Model(board).WherePK().Update()
+			if err != nil {
+				return err
+			}
 		}
 
-		if len(warnings) != 0 {
-			final.WriteString(ctx.S("commands.troubleshoot.warnings"))
-			final.WriteByte('\n')
+		b.Cache.Delete("boards:" + ctx.GuildID)
 
-			for _, warn := range warnings {
-				final.WriteString(warn)
-				final.WriteByte('\n')
+		ctx.Say("commands.board.phrase.updated", name)
+		return nil
+	}
+
+	boards, err := b.getBoards(ctx.GuildID)
+	if err != nil {
+		return
+	}
+
+	if len(boards) == 0 {
+		ctx.Say("commands.board.phrase.empty", ctx.Prefix, ctx.S("commands.board.name"))
+		return
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Color: gray,
+	}
+
+	for _, board := range boards {
+		sources := ctx.S("commands.block.phrase.all")
+		if len(board.Channels) != 0 {
+			sources = "<#" + strings.Join(board.Channels, "> <#") + ">"
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name: board.Name,
+			Value: ctx.S(
+				"commands.board.phrase.info",
+				"<#"+board.ChannelID+">",
+				joinEmojis(boardEmojis(board)),
+				board.Minimum,
+				ctx.S("settings.phrase."+strconv.FormatBool(board.NSFW)),
+				sources,
+			),
+		})
+	}
+
+	_, err = ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, embed)
+	return
+}
+
+func (b *Bot) runTemplate(ctx *commandler.Context) (err error) {
+	current := b.Settings.GetString(ctx.GuildID, settingTemplate)
+
+	if len(ctx.Args) == 0 {
+		if current == "" {
+			ctx.Say("commands.template.phrase.default", defaultTemplate)
+			return
+		}
+
+		ctx.Say("commands.template.phrase.current", current)
+		return
+	}
+
+	action := strings.ToLower(ctx.Args[0])
+	set := ctx.S("commands.template.phrase.set")
+	reset := ctx.S("settings.phrase.reset")
+	preview := ctx.S("commands.template.phrase.preview")
+
+	switch action {
+	case set, reset:
+		memberPerms, err := ctx.Session.State.UserChannelPermissions(ctx.Author.ID, ctx.ChannelID)
+		if err != nil {
+			ctx.Say("restrictions.permissions.missing.member.error")
+			return err
+		}
+
+		if memberPerms&discordgo.PermissionManageMessages == 0 {
+			ctx.Say("restrictions.permissions.missing.member", "```diff\n- "+ctx.S("permissions.MANAGE_MESSAGES")+"\n```")
+			return nil
+		}
+
+		value := ""
+
+		if action == set {
+			value = templateArg(ctx.Args[1:])
+			if value == "" {
+				ctx.Say("commands.template.phrase.missing")
+				return nil
 			}
 
-			final.WriteByte('\n')
+			if _, err := parseTemplate(value); err != nil {
+				sayTemplateError(ctx, err)
+				return nil
+			}
+		}
+
+		if value == "" {
+			err = b.Settings.Delete(ctx.GuildID, settingTemplate)
+		} else {
+			err = b.Settings.Set(ctx.GuildID, settingTemplate, value)
+		}
+		if err != nil {
+			return err
+		}
+
+		ctx.Say("settings.phrase.updated", ctx.S("settings.template"))
+	case preview:
+		if len(ctx.Args) == 1 {
+			ctx.Say("commands.fix.phrase.id")
+			return
+		}
+
+		channelID, messageID, ok := parseMessageArg(ctx, ctx.Args[1])
+		if !ok {
+			ctx.Say("commands.fix.phrase.id")
+			return
+		}
+
+		str := current
+		if value := templateArg(ctx.Args[2:]); value != "" {
+			str = value
+		}
+
+		if str == "" {
+			str = defaultTemplate
 		}
 
-		ctx.SayRaw(final.String())
+		t, err := parseTemplate(str)
+		if err != nil {
+			sayTemplateError(ctx, err)
+			return nil
+		}
+
+		// Previews show the message's content, so they mustn't reveal channels the member can't read
+		if !b.canRead(ctx.Session, ctx.GuildID, channelID, ctx.Author.ID) {
+			ctx.Say("commands.fix.phrase.permissions")
+			return nil
+		}
+
+		msg, err := b.getMessage(ctx.Session, messageID, channelID)
+		if err != nil {
+			if rErr, ok := err.(*discordgo.RESTError); ok && rErr.Message != nil && rErr.Message.Code == discordgo.ErrCodeUnknownMessage {
+				ctx.Say("commands.fix.phrase.unknown_message")
+				return nil
+			}
+
+			return err
+		}
+
+		boards, err := b.getStarboards(ctx.Session, msg.ChannelID, msg.GuildID)
+		if err != nil {
+			return err
+		}
+
+		board := &tables.Board{
+			Name:  defaultBoard,
+			Emoji: b.Settings.GetEmoji(ctx.GuildID, settingEmoji),
+		}
+		if len(boards) != 0 {
+			board = boards[0]
+		}
+
+		count, err := b.countStars(msg, board)
+		if err != nil {
+			return err
+		}
+
+		anti, err := b.countAntiStars(msg)
+		if err != nil {
+			return err
+		}
+
+		_, err = ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, b.renderTemplate(t, msg, board, count-anti, anti))
+		return err
+	default:
+		ctx.SayList("settings.restrictions.one_of", ctx.S("commands.block.phrase.action"), set, reset, preview)
+	}
+
+	return
+}
+
+func (b *Bot) runAction(ctx *commandler.Context) (err error) {
+	if len(ctx.Args) == 0 {
+		actions, err := b.getActions(ctx.GuildID)
+		if err != nil {
+			return err
+		}
+
+		if len(actions) == 0 {
+			ctx.Say("commands.action.phrase.empty", ctx.Prefix, ctx.S("commands.action.name"), ctx.S("commands.action.phrase.add"))
+			return nil
+		}
+
+		var sb strings.Builder
+		for _, a := range actions {
+			sb.WriteString(describeAction(ctx, a))
+			sb.WriteByte('\n')
+		}
+
+		ctx.SayRaw(sb.String())
+		return nil
+	}
+
+	memberPerms, err := ctx.Session.State.UserChannelPermissions(ctx.Author.ID, ctx.ChannelID)
+	if err != nil {
+		ctx.Say("restrictions.permissions.missing.member.error")
+		return
+	}
+
+	if memberPerms&discordgo.PermissionManageMessages == 0 {
+		ctx.Say("restrictions.permissions.missing.member", "```diff\n- "+ctx.S("permissions.MANAGE_MESSAGES")+"\n```")
+		return
+	}
+
+	action := strings.ToLower(ctx.Args[0])
+	add := ctx.S("commands.action.phrase.add")
+	remove := ctx.S("commands.action.phrase.remove")
+
+	if action != add && action != remove {
+		ctx.SayList("settings.restrictions.one_of", ctx.S("commands.block.phrase.action"), add, remove)
+		return
+	}
+
+	if len(ctx.Args) < 3 {
+		ctx.Say("commands.action.phrase.missing")
+		return
+	}
+
+	l := ctx.S("commands.action.phrase.stars")
+
+	threshold, err := strconv.Atoi(ctx.Args[1])
+	if err != nil {
+		ctx.Say("settings.restrictions.number", l)
+		return nil
+	}
+	if threshold < 1 {
+		ctx.Say("settings.restrictions.min", l, 1)
+		return
+	}
+
+	a := &tables.Action{
+		GuildID:   ctx.GuildID,
+		Threshold: threshold,
+		Type:      ctx.Locale("commands.action.to_type." + strings.ToLower(ctx.Args[2])),
+	}
+
+	if a.Type == "" {
+		var types []string
+		for _, t := range actionTypes {
+			types = append(types, ctx.S("commands.action.type_name."+t))
+		}
+
+		ctx.SayList("settings.restrictions.one_of", ctx.S("commands.action.phrase.type"), types...)
+		return
+	}
+
+	typeName := ctx.S("commands.action.type_name." + a.Type)
+
+	switch action {
+	case add:
+		if a.Type == actionRole {
+			if memberPerms&discordgo.PermissionManageRoles == 0 {
+				ctx.Say("restrictions.permissions.missing.member", "```diff\n- "+ctx.S("permissions.MANAGE_ROLES")+"\n```")
+				return
+			}
+
+			roles := ctx.MentionedRoles()
+			if len(roles) == 0 {
+				ctx.Say("commands.action.phrase.missing_role")
+				return
+			}
+
+			a.RoleID = roles[0].ID
+
+			if len(ctx.Args) > 4 {
+				d, err := util.ParseDuration(ctx.Args[4])
+				switch err {
+				case nil:
+				case util.ErrDurationTooLong:
+					ctx.Say("commands.action.phrase.duration_max", util.FormatDuration(util.MaxDuration))
+					return nil
+				default:
+					ctx.Say("commands.action.phrase.duration", ctx.Args[4])
+					return nil
+				}
+
+				a.Duration = d
+			}
+		}
+
+		if problem := b.actionProblem(ctx.Session, ctx.GuildID, "", a); problem != "" {
+			ctx.Say("commands.action.phrase.problem", ctx.S(problem))
+			return
+		}
+
+		actions, err := b.getActions(ctx.GuildID)
+		if err != nil {
+			return err
+		}
+
+		if len(actions) >= maxActions {
+			ctx.Say("commands.action.phrase.max", maxActions)
+			return nil
+		}
+
+		res, err := b.PG.Model(a).OnConflict("DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+
+		if res.RowsAffected() == 0 {
+			ctx.Say("commands.action.phrase.exists", typeName, threshold)
+			return nil
+		}
+	case remove:
+		res, err := b.PG.Model(a).WherePK().Delete()
+		if err != nil && err != pg.ErrNoRows {
+			return err
+		}
+
+		if err == pg.ErrNoRows || res.RowsAffected() == 0 {
+			ctx.Say("commands.action.phrase.unknown", typeName, threshold)
+			return nil
+		}
 	}
 
+	b.Cache.Delete("actions:" + ctx.GuildID)
+
+	ctx.Say("commands.action.phrase.updated")
 	return
 }
+
+// describeAction describes an action in the language of a command
+func describeAction(ctx *commandler.Context, a *tables.Action) string {
+	var str string
+
+	switch {
+	case a.Type != actionRole:
+		str = ctx.S("commands.action.type." + a.Type)
+	case a.Duration == 0:
+		str = ctx.S("commands.action.type.role", "<@&"+a.RoleID+">")
+	default:
+		str = ctx.S("commands.action.type.role_timed", "<@&"+a.RoleID+">", util.FormatDuration(a.Duration))
+	}
+
+	return ctx.S("commands.action.phrase.info", a.Threshold, str)
+}
+
+func (b *Bot) runHistory(ctx *commandler.Context) (err error) {
+	if len(ctx.Args) == 0 {
+		ctx.Say("commands.fix.phrase.id")
+		return
+	}
+
+	_, messageID, ok := parseMessageArg(ctx, ctx.Args[0])
+	if !ok {
+		ctx.Say("commands.fix.phrase.id")
+		return
+	}
+
+	msg := &tables.Message{ID: messageID}
+	err = b.PG.Model(msg).WherePK().Where("guild_id = ?", ctx.GuildID).Select()
+	if err != nil {
+		if err == pg.ErrNoRows {
+			ctx.Say("commands.history.phrase.untracked")
+			return nil
+		}
+
+		return
+	}
+
+	// Revisions show the message's content, so they mustn't reveal channels the member can't read
+	if !b.canRead(ctx.Session, ctx.GuildID, msg.ChannelID, ctx.Author.ID) {
+		ctx.Say("commands.fix.phrase.permissions")
+		return nil
+	}
+
+	if b.isNSFW(ctx.Session, msg.ChannelID) && !b.isNSFW(ctx.Session, ctx.ChannelID) {
+		ctx.Say("commands.history.phrase.nsfw")
+		return nil
+	}
+
+	var revisions []*tables.Revision
+	err = b.PG.Model(&revisions).Where("message_id = ?", msg.ID).Order("edited_at DESC").Limit(25).Select()
+	if err != nil && err != pg.ErrNoRows {
+		return
+	}
+
+	// The first revision is the original, so there's only history after an edit
+	if len(revisions) < 2 {
+		ctx.Say("commands.history.phrase.empty")
+		return nil
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Color: gray,
+		Title: ctx.S("commands.history.phrase.title"),
+		URL:   messageLink(msg),
+	}
+
+	if b.Settings.GetBool(ctx.GuildID, settingFreezeContent) && len(msg.SentIDs) != 0 {
+		embed.Description = ctx.S("commands.history.phrase.frozen")
+	}
+
+	created := util.SnowflakeTimestamp(msg.ID)
+
+	for _, r := range revisions {
+		name := ctx.S("commands.history.phrase.revision", r.EditedAt.UTC().Format("2006-01-02 15:04 UTC"))
+		if r.EditedAt.Equal(created) {
+			name = ctx.S("commands.history.phrase.original")
+		}
+
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:  name,
+			Value: revisionText(ctx, r.Content, r.Image),
+		})
+	}
+
+	ctx.Session.ChannelMessageSendEmbed(ctx.ChannelID, embed)
+	return
+}
+
+func revisionText(ctx *commandler.Context, content, image string) string {
+	if image != "" {
+		content = strings.TrimSpace(content + "\n" + image)
+	}
+
+	if content == "" {
+		return ctx.S("commands.history.phrase.no_content")
+	}
+
+	return truncate(content, 1024)
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/go-pg/pg"
+	"github.com/patrickmn/go-cache"
 
 	"github.com/dbhq/starboard/bot/tables"
 
@@ -87,6 +88,40 @@ func (b *Bot) guildMemberRemove(s *discordgo.Session, m *discordgo.GuildMemberRe
 	})
 }
 
+func (b *Bot) guildMemberUpdate(s *discordgo.Session, m *discordgo.GuildMemberUpdate) (err error) {
+	g, err := s.State.Guild(m.GuildID)
+	if err != nil {
+		return nil
+	}
+
+	// Weights only change when a role with one is added or removed, so guilds without any are skipped
+	var roles []string
+	for _, r := range g.Roles {
+		roles = append(roles, r.ID)
+	}
+
+	if b.weightedRoles(roles) == "" {
+		return
+	}
+
+	// Members are only known to have kept their weighted roles if they were seen since the
+	// bot started, otherwise their weight is recomputed once
+	key := "weighted:" + m.GuildID + ":" + m.User.ID
+	weighted := b.weightedRoles(m.Roles)
+	if x, found := b.Cache.Get(key); found && x.(string) == weighted {
+		return
+	}
+
+	ids, err := b.updateWeight(s, m.GuildID, m.User.ID)
+	if err != nil {
+		return
+	}
+
+	b.Cache.Set(key, weighted, cache.NoExpiration)
+	b.refreshMessages(s, ids)
+	return
+}
+
 func (b *Bot) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) (err error) {
 	if m.GuildID == "" {
 		return
@@ -101,7 +136,7 @@ func (b *Bot) messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) (e
 
 	if rand.Float64() <= (probability / 100) {
 		var perms int
-		perms, err = s.State.UserChannelPermissions(s.State.User.ID, m.ChannelID)
+		perms, err = b.botPermissions(s, m.ChannelID)
 		if err != nil || perms&discordgo.PermissionAddReactions != discordgo.PermissionAddReactions {
 			return
 		}
@@ -120,6 +155,14 @@ func (b *Bot) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) (e
 		return
 	}
 
+	edited := m.EditedTimestamp != ""
+	image := util.GetImage(m.Message)
+
+	// Updates that aren't edits only matter when embeds resolve an image
+	if !edited && image == "" {
+		return
+	}
+
 	b.mutexGroup.Lock(m.ID)
 	defer b.mutexGroup.Unlock(m.ID)
 
@@ -127,37 +170,79 @@ func (b *Bot) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) (e
 
 	if x, found := b.Cache.Get(key); found {
 		data := x.(*tables.Message)
-		if m.EditedTimestamp != "" {
+		if edited {
 			data.Content = util.GetContent(m.Message)
-			data.Image = util.GetImage(m.Message)
+		}
 
-			b.Cache.Set(key, data, expiryTime)
-		} else if image := util.GetImage(m.Message); image != "" {
-			data.Image = util.GetImage(m.Message)
+		data.Image = image
+		data.Gallery = util.GetGallery(m.Message)
 
-			b.Cache.Set(key, data, expiryTime)
-		} else {
-			return
-		}
+		b.Cache.Set(key, data, expiryTime)
+	}
 
+	msg := &tables.Message{ID: m.ID}
+	err = b.PG.Select(msg)
+	if err != nil {
+		if err == pg.ErrNoRows {
+			return nil
+		}
 		return
 	}
 
-	image := util.GetImage(m.Message)
+	// Frozen posts keep showing what the message looked like when it first hit the board
+	frozen := len(msg.SentIDs) != 0 && b.Settings.GetBool(m.GuildID, settingFreezeContent)
+	content := util.GetContent(m.Message)
+	var columns []string
+
+	if edited {
+		editedAt, err := m.EditedTimestamp.Parse()
+		if err != nil {
+			editedAt = time.Now()
+		}
 
-	q := b.PG.
-		Model((*tables.Message)(nil)).
-		Where("id = ?", m.ID)
+		// Updates caused by the same edit share its timestamp, so they're only stored once
+		revisions := []*tables.Revision{
+			{
+				MessageID: msg.ID,
+				EditedAt:  util.SnowflakeTimestamp(msg.ID),
+				GuildID:   m.GuildID,
+				Content:   msg.Content,
+				Image:     msg.Image,
+			},
+			{
+				MessageID: msg.ID,
+				EditedAt:  editedAt,
+				GuildID:   m.GuildID,
+				Content:   content,
+				Image:     image,
+			},
+		}
 
-	if m.EditedTimestamp != "" {
-		q = q.Set("content = ?", m.Content).Set("image = ?", image)
-	} else if image != "" {
-		q = q.Set("image = ?", image)
-	} else {
+		_, err = b.PG.Model(&revisions).OnConflict("DO NOTHING").Insert()
+		if err != nil {
+			return err
+		}
+
+		msg.EditedAt = editedAt
+		columns = append(columns, "edited_at")
+
+		if !frozen {
+			msg.Content = content
+			columns = append(columns, "content")
+		}
+	}
+
+	if !frozen {
+		msg.Image = image
+		msg.Gallery = util.GetGallery(m.Message)
+		columns = append(columns, "image", "gallery")
+	}
+
+	if len(columns) == 0 {
 		return
 	}
 
-	_, err = q.Update()
+	_, err = b.PG.Model(msg).Column(columns...).WherePK().Update()
 	if err != nil {
 		return
 	}
@@ -174,12 +259,37 @@ func (b *Bot) messageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate) (e
 	return
 }
 
+// What happens to the starboard posts of a message that was deleted
+const (
+	deletedRemove = "remove"
+	deletedKeep   = "keep"
+	deletedMark   = "mark"
+)
+
+// deletedMode gets what happens to the posts of a guild's deleted messages. Marking them
+// takes precedence, since it keeps the posts anyway.
+func (b *Bot) deletedMode(guildID string) string {
+	return deletedModeOf(b.Settings.GetBool(guildID, settingSaveDeletedMessages), b.Settings.GetBool(guildID, settingMarkDeletedMessages))
+}
+
+func deletedModeOf(save, mark bool) string {
+	switch {
+	case mark:
+		return deletedMark
+	case save:
+		return deletedKeep
+	}
+
+	return deletedRemove
+}
+
 func (b *Bot) messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) (err error) {
 	if m.GuildID == "" {
 		return
 	}
 
-	if b.Settings.GetBool(m.GuildID, settingSaveDeletedMessages) {
+	mode := b.deletedMode(m.GuildID)
+	if mode == deletedKeep {
 		return
 	}
 
@@ -195,16 +305,15 @@ func (b *Bot) messageDelete(s *discordgo.Session, m *discordgo.MessageDelete) (e
 		return
 	}
 
-	starboard := b.getStarboard(s, msg.ChannelID, msg.GuildID)
-	if starboard == settingNone {
-		return
+	if mode == deletedMark {
+		return b.markDeleted(s, msg)
 	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
-		s.ChannelMessageDelete(starboard, msg.SentID)
+		b.deletePosts(s, msg)
 		wg.Done()
 	}()
 	go func() {
@@ -222,7 +331,8 @@ func (b *Bot) messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDelete
 		return
 	}
 
-	if b.Settings.GetBool(m.GuildID, settingSaveDeletedMessages) {
+	mode := b.deletedMode(m.GuildID)
+	if mode == deletedKeep {
 		return
 	}
 
@@ -235,7 +345,7 @@ func (b *Bot) messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDelete
 	}
 
 	var rows []tables.Message
-	err = b.PG.Model(&rows).WhereIn("id IN (?)", args...).Returning("sent_id").Select()
+	err = b.PG.Model(&rows).WhereIn("id IN (?)", args...).Select()
 	if err != nil {
 		if err == pg.ErrNoRows {
 			return nil
@@ -243,27 +353,33 @@ func (b *Bot) messageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDelete
 		return
 	}
 
-	msg := &tables.Message{
-		ChannelID: m.ChannelID,
-		GuildID:   m.GuildID,
-	}
+	if mode == deletedMark {
+		for i := range rows {
+			if err = b.markDeleted(s, &rows[i]); err != nil {
+				return
+			}
+		}
 
-	starboard := b.getStarboard(s, msg.ChannelID, msg.GuildID)
-	if starboard == settingNone {
 		return
 	}
 
-	messages := make([]string, len(rows))
+	messages := make(map[string][]string)
 
-	for i, row := range rows {
-		messages[i] = row.SentID
+	for _, row := range rows {
+		for name, sentID := range row.SentIDs {
+			if starboard := b.getBoardChannel(s, &row, name); starboard != settingNone {
+				messages[starboard] = append(messages[starboard], sentID)
+			}
+		}
 	}
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
-		s.ChannelMessagesBulkDelete(starboard, messages)
+		for starboard, ids := range messages {
+			s.ChannelMessagesBulkDelete(starboard, ids)
+		}
 		wg.Done()
 	}()
 	go func() {
@@ -281,13 +397,9 @@ func (b *Bot) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReact
 		return
 	}
 
-	emoji := b.Settings.GetEmoji(m.GuildID, settingEmoji)
-
-	if m.Emoji.ID == "" {
-		if m.Emoji.Name != emoji.Unicode {
-			return
-		}
-	} else if m.Emoji.ID != emoji.ID {
+	key := util.EmojiKey(&m.Emoji)
+	kind, err := b.reactionKind(m.GuildID, key)
+	if err != nil || kind == "" {
 		return
 	}
 
@@ -295,11 +407,11 @@ func (b *Bot) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReact
 	defer b.mutexGroup.Unlock(m.MessageID)
 
 	member, err := s.State.Member(m.GuildID, m.UserID)
-	perms, _ := s.State.UserChannelPermissions(s.State.User.ID, m.ChannelID)
+	perms, _ := b.botPermissions(s, m.ChannelID)
+	mm := perms&discordgo.PermissionManageMessages == discordgo.PermissionManageMessages
 	bot := false
 
 	if m.UserID != s.State.User.ID {
-		mm := perms&discordgo.PermissionManageMessages == discordgo.PermissionManageMessages
 		if err == nil && member.User.Bot {
 			bot = true
 
@@ -309,7 +421,7 @@ func (b *Bot) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReact
 					return
 				}
 			}
-		} else if mm && !b.Settings.GetBool(m.GuildID, settingSelfStar) {
+		} else if mm && kind == reactionStar && !b.Settings.GetBool(m.GuildID, settingSelfStar) {
 			msg, err := b.getMessage(s, m.MessageID, m.ChannelID)
 			if err != nil {
 				return err
@@ -320,7 +432,7 @@ func (b *Bot) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReact
 				if err == nil {
 					key := "warned:" + m.UserID
 
-					if x, found := b.Cache.Get(key); found {
+					if _, found := b.Cache.Get(key); !found {
 						if b.Settings.GetBool(m.GuildID, settingSelfStarWarning) {
 							b.Cache.Set(key, "", time.Hour)
 							l := b.Locales.Language(b.Settings.GetString(msg.GuildID, settingLanguage))
@@ -332,12 +444,46 @@ func (b *Bot) messageReactionAdd(s *discordgo.Session, m *discordgo.MessageReact
 		}
 	}
 
+	ignored := false
+	if m.UserID != s.State.User.ID && !bot && kind == reactionStar {
+		ignored, err = b.overBudget(m.GuildID, m.UserID, m.MessageID)
+		if err != nil {
+			return
+		}
+
+		if ignored && mm && b.Settings.GetBool(m.GuildID, settingRemoveOverBudget) {
+			err = s.MessageReactionRemove(m.ChannelID, m.MessageID, m.Emoji.APIName(), m.UserID)
+			if err == nil {
+				key := "budget:" + m.GuildID + ":" + m.UserID
+
+				if _, found := b.Cache.Get(key); !found {
+					b.Cache.Set(key, "", time.Hour)
+					l := b.Locales.Language(b.Settings.GetString(m.GuildID, settingLanguage))
+					s.ChannelMessageSend(m.ChannelID, l("starboard.star_budget.warning", "<@"+m.UserID+">", b.Settings.GetInt(m.GuildID, settingStarBudget)))
+				}
+
+				return
+			}
+		}
+	}
+
+	// Fetching the message may already have stored this reaction without knowing when it was added
 	_, err = b.PG.Model(&tables.Reaction{
 		Bot:       bot,
 		UserID:    m.UserID,
 		MessageID: m.MessageID,
-	}).OnConflict("DO NOTHING").Insert()
-	if err != nil {
+		Emoji:     key,
+		GuildID:   m.GuildID,
+		ChannelID: m.ChannelID,
+		Weight:    b.memberWeight(s, m.GuildID, m.UserID),
+		JoinedAt:  memberJoinedAt(s, m.GuildID, m.UserID),
+		Ignored:   ignored,
+		Kind:      kind,
+	}).
+		OnConflict("(user_id, message_id, emoji) DO UPDATE").
+		Set("created_at = EXCLUDED.created_at, ignored = EXCLUDED.ignored").
+		Insert()
+	if err != nil || ignored {
 		return
 	}
 
@@ -353,13 +499,9 @@ func (b *Bot) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageRe
 		return
 	}
 
-	emoji := b.Settings.GetEmoji(m.GuildID, settingEmoji)
-
-	if m.Emoji.ID == "" {
-		if m.Emoji.Name != emoji.Unicode {
-			return
-		}
-	} else if m.Emoji.ID != emoji.ID {
+	key := util.EmojiKey(&m.Emoji)
+	kind, err := b.reactionKind(m.GuildID, key)
+	if err != nil || kind == "" {
 		return
 	}
 
@@ -369,6 +511,7 @@ func (b *Bot) messageReactionRemove(s *discordgo.Session, m *discordgo.MessageRe
 	err = b.PG.Delete(&tables.Reaction{
 		UserID:    m.UserID,
 		MessageID: m.MessageID,
+		Emoji:     key,
 	})
 	if err != nil && err != pg.ErrNoRows {
 		return
@@ -406,16 +549,11 @@ func (b *Bot) messageReactionRemoveAll(s *discordgo.Session, m *discordgo.Messag
 		return
 	}
 
-	starboard := b.getStarboard(s, msg.ChannelID, msg.GuildID)
-	if starboard == settingNone {
-		return
-	}
-
 	var wg sync.WaitGroup
 	wg.Add(2)
 
 	go func() {
-		s.ChannelMessageDelete(starboard, msg.SentID)
+		b.deletePosts(s, msg)
 		wg.Done()
 	}()
 	go func() {
@@ -0,0 +1,19 @@
+package bot
+
+import "testing"
+
+func TestDeletedModeOf(t *testing.T) {
+	for _, test := range []struct {
+		save, mark bool
+		want       string
+	}{
+		{false, false, deletedRemove},
+		{true, false, deletedKeep},
+		{true, true, deletedMark},
+		{false, true, deletedMark},
+	} {
+		if got := deletedModeOf(test.save, test.mark); got != test.want {
+			t.Errorf("deletedModeOf(%v, %v) = %q, want %q", test.save, test.mark, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package bot
+
+// migrations bring tables created by older versions up to date. They run in
+// order on every startup, after the tables are created, so each one must be
+// safe to run again.
+var migrations = [...]string{
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS sent_ids jsonb`,
+
+	`DO $$ BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'messages' AND column_name = 'sent_id') THEN
+			UPDATE messages SET sent_ids = jsonb_build_object('default', sent_id) WHERE sent_ids IS NULL;
+			ALTER TABLE messages DROP COLUMN sent_id;
+		END IF;
+	END $$`,
+
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS emoji text NOT NULL DEFAULT ''`,
+
+	`UPDATE reactions SET emoji = COALESCE((
+		SELECT COALESCE(NULLIF(split_part(settings.value, ',', 2), ''), split_part(settings.value, ',', 4))
+		FROM settings
+		JOIN messages ON messages.guild_id = settings.id
+		WHERE messages.id = reactions.message_id AND settings.key = 'emoji'
+	), '⭐') WHERE emoji = ''`,
+
+	`DO $$ BEGIN
+		IF NOT EXISTS (
+			SELECT 1 FROM information_schema.key_column_usage
+			WHERE table_name = 'reactions' AND constraint_name = 'reactions_pkey' AND column_name = 'emoji'
+		) THEN
+			ALTER TABLE reactions DROP CONSTRAINT reactions_pkey, ADD PRIMARY KEY (user_id, message_id, emoji);
+		END IF;
+	END $$`,
+
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS gallery jsonb`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS reply jsonb`,
+
+	// Existing reactions can't be older than their message, which is the closest known time
+	`DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'reactions' AND column_name = 'created_at') THEN
+			ALTER TABLE reactions ADD COLUMN created_at timestamptz;
+			UPDATE reactions SET created_at = to_timestamp(((message_id::bigint >> 22) + 1420070400000) / 1000.0);
+			ALTER TABLE reactions ALTER COLUMN created_at SET DEFAULT now(), ALTER COLUMN created_at SET NOT NULL;
+		END IF;
+	END $$`,
+
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS actions jsonb`,
+	`ALTER TABLE boards ADD COLUMN IF NOT EXISTS emojis jsonb`,
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS weight bigint NOT NULL DEFAULT 1`,
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS guild_id text NOT NULL DEFAULT ''`,
+
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS joined_at timestamptz`,
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS ignored boolean NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS kind text NOT NULL DEFAULT 'star'`,
+
+	`UPDATE reactions SET guild_id = messages.guild_id
+		FROM messages
+		WHERE messages.id = reactions.message_id AND reactions.guild_id = ''`,
+
+	`ALTER TABLE blocks ADD COLUMN IF NOT EXISTS moderator_id text`,
+	`ALTER TABLE blocks ADD COLUMN IF NOT EXISTS reason text`,
+	`ALTER TABLE blocks ADD COLUMN IF NOT EXISTS expires_at timestamptz`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS deleted_at timestamptz`,
+	`ALTER TABLE messages ADD COLUMN IF NOT EXISTS edited_at timestamptz`,
+
+	`ALTER TABLE reactions ADD COLUMN IF NOT EXISTS channel_id text`,
+}
+
+// createSearchIndex indexes the content of messages for searches in a language. Searches
+// can only use the index built for their own language, so each language gets one.
+func (b *Bot) createSearchIndex(lang string) (err error) {
+	_, err = b.PG.Exec(`CREATE INDEX IF NOT EXISTS messages_search_` + lang + ` ON messages USING gin (to_tsvector('` + lang + `', content))`)
+	return
+}
+
+func (b *Bot) migrate() (err error) {
+	for _, m := range migrations {
+		_, err = b.PG.Exec(m)
+		if err != nil {
+			return
+		}
+	}
+
+	return b.createSearchIndex(defaultSearchLanguage)
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/dbhq/discordgo"
+	"github.com/dbhq/starboard/bot/tables"
+	"github.com/dbhq/starboard/bot/util"
+)
+
+const (
+	reconcileInterval = 15 * time.Minute
+	reconcileWindow   = 48 * time.Hour
+
+	// reconcileBudget is the maximum number of messages reconciled per guild and run
+	reconcileBudget = 10
+
+	// reconcileDelay spaces out the requests of a run, leaving the rate limits to events and commands
+	reconcileDelay = 500 * time.Millisecond
+)
+
+// initReconciler periodically reconciles recently starred messages with Discord. This catches
+// up on reactions missed while a shard was disconnected and reposts deleted starboard posts.
+func (b *Bot) initReconciler(d time.Duration) {
+	for range time.NewTicker(d).C {
+		b.capturePanic(func() {
+			b.reportError(b.reconcile(), map[string]string{"task": "reconcile"})
+		}, map[string]string{"task": "reconcile"})
+	}
+}
+
+func (b *Bot) reconcile() (err error) {
+	var msgs []*tables.Message
+
+	// Besides the recent posts, messages starred recently are picked up even if they aren't
+	// posted yet, as missed reactions may be what keeps them below the minimum
+	_, err = b.PG.Query(&msgs, `
+	SELECT * FROM (
+		SELECT id, channel_id, guild_id FROM messages
+		WHERE id::bigint > ? AND deleted_at IS NULL
+		UNION
+		SELECT DISTINCT message_id, channel_id, guild_id FROM reactions
+		WHERE created_at > ? AND channel_id IS NOT NULL
+		AND NOT EXISTS (SELECT 1 FROM messages WHERE messages.id = reactions.message_id)
+	) AS recent
+	ORDER BY id::bigint DESC
+	`, util.TimestampSnowflake(time.Now().Add(-reconcileWindow)), time.Now().Add(-reconcileWindow))
+	if err != nil {
+		return
+	}
+
+	budgets := make(map[string]int)
+
+	for _, msg := range msgs {
+		if budgets[msg.GuildID] == reconcileBudget {
+			continue
+		}
+
+		s := b.Manager.SessionForGuildS(msg.GuildID)
+		if s == nil {
+			continue
+		}
+
+		if _, err := s.State.Guild(msg.GuildID); err != nil {
+			continue
+		}
+
+		budgets[msg.GuildID]++
+
+		if err := b.reconcileMessage(s, msg); err != nil {
+			if isRateLimited(err) {
+				return nil
+			}
+
+			// Discord refusing a single message, for example because it was deleted
+			// or the bot lost access to its channel, shouldn't stop the run
+			if restErrorCode(err) == 0 {
+				b.reportError(err, map[string]string{"task": "reconcile", "message": msg.ID})
+			}
+		}
+
+		time.Sleep(reconcileDelay)
+	}
+
+	return
+}
+
+// reconcileMessage resyncs the reactions of a message and updates its starboard posts
+func (b *Bot) reconcileMessage(s *discordgo.Session, msg *tables.Message) (err error) {
+	b.mutexGroup.Lock(msg.ID)
+	defer b.mutexGroup.Unlock(msg.ID)
+
+	_, err = b.fetchMessage(s, msg.ID, msg.ChannelID)
+	if err != nil {
+		return
+	}
+
+	return b.updateMessage(s, &tables.Message{
+		ID:        msg.ID,
+		ChannelID: msg.ChannelID,
+		GuildID:   msg.GuildID,
+	})
+}
+
+// isRateLimited checks whether a request failed because the bot is being rate limited
+func isRateLimited(err error) bool {
+	rErr, ok := err.(*discordgo.RESTError)
+	return ok && rErr.Response != nil && rErr.Response.StatusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"time"
+
+	"github.com/dbhq/discordgo"
+	"github.com/dbhq/starboard/bot/tables"
+	"github.com/go-pg/pg"
+)
+
+// initScheduler periodically undoes whatever was only meant to last for a limited time
+func (b *Bot) initScheduler(d time.Duration) {
+	for range time.NewTicker(d).C {
+		b.capturePanic(func() {
+			b.reportError(b.expireRoles(), map[string]string{"task": "expire_roles"})
+			b.reportError(b.expireBlocks(), map[string]string{"task": "expire_blocks"})
+		}, map[string]string{"task": "scheduler"})
+	}
+}
+
+// expireRoles removes the roles granted by actions whose time is up
+func (b *Bot) expireRoles() (err error) {
+	var roles []*tables.TimedRole
+
+	err = b.PG.Model(&roles).Where("expires_at <= ?", time.Now()).Select()
+	if err != nil {
+		return
+	}
+
+	for _, role := range roles {
+		s := b.Manager.SessionForGuildS(role.GuildID)
+		if s == nil {
+			continue
+		}
+
+		err = s.GuildMemberRoleRemove(role.GuildID, role.UserID, role.RoleID)
+		if err != nil {
+			switch restErrorCode(err) {
+			case discordgo.ErrCodeUnknownMember, discordgo.ErrCodeUnknownRole, discordgo.ErrCodeUnknownGuild:
+			default:
+				b.reportError(err, map[string]string{"task": "expire_roles", "guild": role.GuildID})
+				continue
+			}
+		}
+
+		_, err = b.PG.Model(role).WherePK().Delete()
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// expireBlocks lifts temporary blocks whose time is up
+func (b *Bot) expireBlocks() (err error) {
+	_, err = b.PG.Model((*tables.Block)(nil)).Where("expires_at <= ?", time.Now()).Delete()
+	if err == pg.ErrNoRows {
+		err = nil
+	}
+
+	return
+}
@@ -76,6 +76,19 @@ func (s *Settings) Get(id, key string) interface{} {
 	return value
 }
 
+// Lookup gets a setting without falling back to its default and reports whether it was set
+func (s *Settings) Lookup(id, key string) (interface{}, bool) {
+	s.mu.RLock()
+	cache, ok := s.cache[id]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	return cache.Load(key)
+}
+
 // GetID gets all the settings of an ID
 func (s *Settings) GetID(id string) map[string]interface{} {
 	s.mu.RLock()
@@ -114,11 +127,29 @@ func (s *Settings) GetBool(id, key string) bool {
 	return s.Get(id, key).(bool)
 }
 
-// GetEmoji gets a setting as an emoji
+// GetEmoji gets a setting as an emoji, or the first one of a list of emojis
 func (s *Settings) GetEmoji(id, key string) *util.Emoji {
+	if emojis, ok := s.Get(id, key).([]*util.Emoji); ok {
+		return emojis[0]
+	}
+
 	return s.Get(id, key).(*util.Emoji)
 }
 
+// GetEmojis gets a setting as a list of emojis, which may have been stored as a single emoji
+func (s *Settings) GetEmojis(id, key string) []*util.Emoji {
+	if e, ok := s.Get(id, key).(*util.Emoji); ok {
+		return []*util.Emoji{e}
+	}
+
+	return s.Get(id, key).([]*util.Emoji)
+}
+
+// GetTiers gets a setting as a list of tiers
+func (s *Settings) GetTiers(id, key string) []*util.Tier {
+	return s.Get(id, key).([]*util.Tier)
+}
+
 // Set sets a setting
 func (s *Settings) Set(id, key string, value interface{}) (err error) {
 	s.mu.RLock()
@@ -142,3 +173,20 @@ func (s *Settings) Set(id, key string, value interface{}) (err error) {
 		Insert()
 	return
 }
+
+// Delete deletes a setting so its default is used again
+func (s *Settings) Delete(id, key string) (err error) {
+	s.mu.RLock()
+	cache, ok := s.cache[id]
+	s.mu.RUnlock()
+
+	if ok {
+		cache.Delete(key)
+	}
+
+	_, err = s.db.
+		Model(&Setting{ID: id, Key: key}).
+		WherePK().
+		Delete()
+	return
+}
@@ -1,6 +1,7 @@
 package settings
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
@@ -35,6 +36,14 @@ func serialize(v interface{}) string {
 
 		return str + "," + e.ID + "," + e.Name + "," + e.Unicode
 
+	case []*util.Emoji:
+		data, _ := json.Marshal(val)
+		return "e" + string(data)
+
+	case []*util.Tier:
+		data, _ := json.Marshal(val)
+		return "t" + string(data)
+
 	default:
 		panic(fmt.Errorf("Unhandled type: %T", v))
 	}
@@ -63,6 +72,18 @@ func deserialize(str string) interface{} {
 		return str[1:]
 	}
 
+	if str[0] == 'e' {
+		var emojis []*util.Emoji
+		json.Unmarshal([]byte(str[1:]), &emojis)
+		return emojis
+	}
+
+	if str[0] == 't' {
+		var tiers []*util.Tier
+		json.Unmarshal([]byte(str[1:]), &tiers)
+		return tiers
+	}
+
 	split := strings.Split(str, ",")
 
 	return &util.Emoji{
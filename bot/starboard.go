@@ -1,8 +1,11 @@
 package bot
 
 import (
+	"fmt"
 	"math/rand"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dbhq/starboard/bot/util"
@@ -10,17 +13,53 @@ import (
 	"github.com/dbhq/discordgo"
 	"github.com/dbhq/starboard/bot/tables"
 	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
 	"github.com/jinzhu/inflection"
 )
 
 const expiryTime = time.Minute * 20
 const gray = 0x2E3036
 
-var styles = [...]struct{ max, color int }{
-	{100, 0x6F29CE},
-	{50, 0xFFB549},
-	{10, 0xFFB13F},
-	{0, 0xFFAC33},
+// maxReplyLength is the length of the snippet shown of the message a starred message replied to
+const maxReplyLength = 100
+
+// maxGallerySize is the amount of images Discord shows in a gallery
+const maxGallerySize = 4
+
+// accountCreatedAt is the SQL equivalent of util.SnowflakeTimestamp for the user of a reaction
+const accountCreatedAt = "to_timestamp(((user_id::bigint >> 22) + 1420070400000) / 1000.0)"
+
+// messageCreatedAt is the SQL equivalent of util.SnowflakeTimestamp for the message of a guildStars row
+const messageCreatedAt = "to_timestamp(((message.id::bigint >> 22) + 1420070400000) / 1000.0)"
+
+const (
+	reactionStar = "star"
+	reactionAnti = "anti"
+)
+
+// defaultBoard is the name of the board configured through the channel settings
+const defaultBoard = "default"
+
+// maxTiers is the amount of tiers a guild can define
+const maxTiers = 10
+
+// defaultTiers are used until a guild defines its own, sorted by descending threshold
+var defaultTiers = []*util.Tier{
+	{Threshold: 100, Color: 0x6F29CE},
+	{Threshold: 50, Color: 0xFFB549},
+	{Threshold: 10, Color: 0xFFB13F},
+	{Threshold: 0, Color: 0xFFAC33},
+}
+
+// getTier gets the tier reached by a star count, or nil if it didn't reach any
+func (b *Bot) getTier(guildID string, count int) *util.Tier {
+	for _, tier := range b.Settings.GetTiers(guildID, settingTiers) {
+		if count >= tier.Threshold {
+			return tier
+		}
+	}
+
+	return nil
 }
 
 func init() {
@@ -29,7 +68,7 @@ func init() {
 
 func (b *Bot) getStarboard(s *discordgo.Session, channelID, guildID string) (starboard string) {
 	setting := settingChannel
-	c, err := s.State.Channel(channelID)
+	c, err := b.sourceChannel(s, channelID)
 	if err != nil || c.NSFW {
 		setting = settingNSFWChannel
 	}
@@ -56,18 +95,176 @@ func (b *Bot) getStarboard(s *discordgo.Session, channelID, guildID string) (sta
 	return
 }
 
-func (b *Bot) generateEmbed(msg *tables.Message, count int) (embed *discordgo.MessageEmbed) {
-	emoji := b.Settings.GetEmoji(msg.GuildID, settingEmoji)
+// getBoards gets the named boards of a guild
+func (b *Bot) getBoards(guildID string) (boards []*tables.Board, err error) {
+	key := "boards:" + guildID
+	if x, found := b.Cache.Get(key); found {
+		return x.([]*tables.Board), nil
+	}
+
+	err = b.PG.Model(&boards).Where("guild_id = ?", guildID).Order("name").Select()
+	if err != nil && err != pg.ErrNoRows {
+		return nil, err
+	}
+
+	b.Cache.Set(key, boards, expiryTime)
+	return boards, nil
+}
+
+// getStarboards gets every board that messages from a channel are posted to
+func (b *Bot) getStarboards(s *discordgo.Session, channelID, guildID string) (boards []*tables.Board, err error) {
+	if starboard := b.getStarboard(s, channelID, guildID); starboard != settingNone {
+		emojis := b.Settings.GetEmojis(guildID, settingEmoji)
+
+		boards = append(boards, &tables.Board{
+			Name:      defaultBoard,
+			GuildID:   guildID,
+			ChannelID: starboard,
+			Emoji:     emojis[0],
+			Emojis:    emojis[1:],
+			Minimum:   b.getMinimum(s, channelID, guildID),
+		})
+	}
+
+	named, err := b.getBoards(guildID)
+	if err != nil {
+		return
+	}
+
+	nsfw := true
+	sourceID := channelID
+	if c, err := b.sourceChannel(s, channelID); err == nil {
+		nsfw = c.NSFW
+		sourceID = c.ID
+	}
+
+	for _, board := range named {
+		if board.NSFW == nsfw && (hasSource(board, channelID) || hasSource(board, sourceID)) {
+			boards = append(boards, board)
+		}
+	}
+
+	return
+}
+
+// getMinimum gets the minimum of the default board for a channel, preferring an override of
+// the channel itself over one of the parent of a thread and that over one of its category
+func (b *Bot) getMinimum(s *discordgo.Session, channelID, guildID string) int {
+	if v, ok := b.channelMinimum(channelID, guildID); ok {
+		return v
+	}
+
+	if c, err := b.sourceChannel(s, channelID); err == nil {
+		if c.ID != channelID {
+			if v, ok := b.channelMinimum(c.ID, guildID); ok {
+				return v
+			}
+		}
+
+		if c.ParentID != "" {
+			if v, ok := b.channelMinimum(c.ParentID, guildID); ok {
+				return v
+			}
+		}
+	}
+
+	return b.Settings.GetInt(guildID, settingMinimum)
+}
+
+// getBoardChannel gets the channel of the board a message was posted to
+func (b *Bot) getBoardChannel(s *discordgo.Session, msg *tables.Message, name string) string {
+	if name == defaultBoard {
+		return b.getStarboard(s, msg.ChannelID, msg.GuildID)
+	}
+
+	boards, err := b.getBoards(msg.GuildID)
+	if err != nil {
+		return settingNone
+	}
+
+	for _, board := range boards {
+		if board.Name == name {
+			return board.ChannelID
+		}
+	}
+
+	return settingNone
+}
+
+// acceptedEmojis gets every emoji that counts as a star in a guild
+func (b *Bot) acceptedEmojis(guildID string) (emojis []*util.Emoji, err error) {
+	emojis = append(emojis, b.Settings.GetEmojis(guildID, settingEmoji)...)
+
+	boards, err := b.getBoards(guildID)
+	if err != nil {
+		return
+	}
+
+	for _, board := range boards {
+		emojis = append(emojis, boardEmojis(board)...)
+	}
+
+	return
+}
+
+// antiStarEmoji gets the emoji whose reactions are subtracted from the star count, or nil if there's none
+func (b *Bot) antiStarEmoji(guildID string) *util.Emoji {
+	e, _ := b.Settings.Get(guildID, settingAntiStar).(*util.Emoji)
+	return e
+}
+
+// reactionKind gets whether reactions with an emoji are stars or anti-stars in a guild, or "" if they're neither
+func (b *Bot) reactionKind(guildID, key string) (string, error) {
+	emojis, err := b.acceptedEmojis(guildID)
+	if err != nil {
+		return "", err
+	}
+
+	if hasEmoji(emojis, key) {
+		return reactionStar, nil
+	}
+
+	if e := b.antiStarEmoji(guildID); e != nil && e.Key() == key {
+		return reactionAnti, nil
+	}
+
+	return "", nil
+}
+
+// boardEmojis gets every emoji that counts as a star on a board
+func boardEmojis(board *tables.Board) []*util.Emoji {
+	return append([]*util.Emoji{board.Emoji}, board.Emojis...)
+}
+
+func (b *Bot) generateEmbed(msg *tables.Message, board *tables.Board, count, anti int) (embed *discordgo.MessageEmbed) {
+	if str := b.Settings.GetString(msg.GuildID, settingTemplate); str != "" {
+		if t, err := parseTemplate(str); err == nil {
+			return b.renderTemplate(t, msg, board, count, anti)
+		}
+	}
+
+	emoji := *board.Emoji
+	tier := b.getTier(msg.GuildID, count)
 	minimal := b.Settings.GetBool(msg.GuildID, settingMinimal)
+
+	if tier != nil && tier.Emoji != nil {
+		emoji = *tier.Emoji
+	}
 	s := b.Locales.Language(b.Settings.GetString(msg.GuildID, settingLanguage))
 
+	// The jump link of a deleted message would lead nowhere
+	channel := fmt.Sprintf("<#%s> [(Jump)](%s)", msg.ChannelID, messageLink(msg))
+	if !msg.DeletedAt.IsZero() {
+		channel = fmt.Sprintf("<#%s> %s", msg.ChannelID, s("message.deleted"))
+	}
+
 	embed = &discordgo.MessageEmbed{
 		Author: &discordgo.MessageEmbedAuthor{
 			Name: msg.Username,
 			URL:  msg.Avatar,
 		},
 		Color:       gray,
-		Description: msg.Content,
+		Description: truncate(replyLine(msg, s)+msg.Content, 2048),
 		Fields: []*discordgo.MessageEmbedField{
 			{
 				Name:   s("message.author"),
@@ -76,7 +273,7 @@ func (b *Bot) generateEmbed(msg *tables.Message, count int) (embed *discordgo.Me
 			},
 			{
 				Name:   s("message.channel"),
-				Value:  fmt.Sprintf("<#%s> [(Jump)](https://discordapp.com/channels/%s/%s/%s)", msg.ChannelID, msg.GuildID, msg.ChannelID, msg.ID),
+				Value:  channel,
 				Inline: true,
 			},
 		},
@@ -101,14 +298,23 @@ func (b *Bot) generateEmbed(msg *tables.Message, count int) (embed *discordgo.Me
 		embed.Footer.Text += " " + emoji.Name
 		embed.Timestamp = util.SnowflakeTimestamp(msg.ID).Format(time.RFC3339)
 
-		for _, style := range styles {
-			if count >= style.max {
-				embed.Color = style.color
-				break
+		if tier != nil {
+			embed.Color = tier.Color
+
+			if tier.Label != "" {
+				embed.Footer.Text += " • " + tier.Label
 			}
 		}
 	}
 
+	if anti != 0 {
+		embed.Footer.Text += " • " + b.antiStarText(msg.GuildID, anti)
+	}
+
+	if !msg.EditedAt.IsZero() {
+		embed.Footer.Text += " • " + s("message.edited")
+	}
+
 	if msg.Image != "" {
 		embed.Image = &discordgo.MessageEmbedImage{
 			URL: msg.Image,
@@ -118,191 +324,639 @@ func (b *Bot) generateEmbed(msg *tables.Message, count int) (embed *discordgo.Me
 	return
 }
 
+// antiStarText shows the anti-stars of a message. Footers can't render custom
+// emojis, so those are shown by name instead.
+func (b *Bot) antiStarText(guildID string, anti int) string {
+	e := b.antiStarEmoji(guildID)
+	if e == nil {
+		return ""
+	}
+
+	if e.Unicode != "" {
+		return e.Unicode + " " + strconv.Itoa(anti)
+	}
+
+	return ":" + e.Name + ": " + strconv.Itoa(anti)
+}
+
+// generateEmbeds generates the embed of a message followed by the rest of its
+// images, which Discord shows as a gallery since they share the same URL
+func (b *Bot) generateEmbeds(msg *tables.Message, board *tables.Board, count, anti int) []*discordgo.MessageEmbed {
+	embed := b.generateEmbed(msg, board, count, anti)
+	embeds := []*discordgo.MessageEmbed{embed}
+
+	if embed.Image == nil {
+		return embeds
+	}
+
+	embed.URL = messageLink(msg)
+
+	for _, image := range msg.Gallery {
+		if len(embeds) == maxGallerySize {
+			break
+		}
+
+		embeds = append(embeds, &discordgo.MessageEmbed{
+			URL:   embed.URL,
+			Image: &discordgo.MessageEmbedImage{URL: image},
+		})
+	}
+
+	return embeds
+}
+
 func (b *Bot) getMessage(s *discordgo.Session, id, channel string) (msg *tables.Message, err error) {
 	key := "messages:" + id
-	res, found := b.Cache.Get(key)
-	if !found {
+
+	// A cached message is only used from the channel it was sent in, so that a message can't
+	// be looked up through a link pointing at another channel
+	if res, found := b.Cache.Get(key); found && res.(*tables.Message).ChannelID == channel {
+		data := res.(*tables.Message)
+
+		msg = &tables.Message{
+			ID:        id,
+			AuthorID:  data.AuthorID,
+			Username:  data.Username,
+			Avatar:    data.Avatar,
+			ChannelID: channel,
+			GuildID:   data.GuildID,
+
+			Content: data.Content,
+			Image:   data.Image,
+			Gallery: data.Gallery,
+			Reply:   data.Reply,
+		}
+
+		return
+	}
+
+	return b.fetchMessage(s, id, channel)
+}
+
+// fetchMessage gets a message from Discord, bypassing the cache, and syncs its reactions
+func (b *Bot) fetchMessage(s *discordgo.Session, id, channel string) (msg *tables.Message, err error) {
+	m, err := s.ChannelMessage(channel, id)
+	if err != nil {
 		return nil, err
 	}
 
-	if res != "" {
-		data := res.(*tables.Message)
+	c, err := b.getChannel(s, m.ChannelID)
+	if err != nil {
+		return nil, err
+	}
 
-		m, err := s.ChannelMessage(channel, id)
-		if err != nil {
-			return nil, err
+	m.GuildID = c.GuildID
+
+	msg = &tables.Message{
+		ID:        id,
+		AuthorID:  m.Author.ID,
+		Username:  m.Author.Username,
+		Avatar:    m.Author.AvatarURL(""),
+		ChannelID: m.ChannelID,
+		GuildID:   m.GuildID,
+
+		Content: util.GetContent(m),
+		Image:   util.GetImage(m),
+		Gallery: util.GetGallery(m),
+		Reply:   getReply(m),
+	}
+
+	err = b.syncReactions(s, m)
+	if err != nil {
+		return nil, err
+	}
+
+	go b.cacheMessage(m)
+	return
+}
+
+// syncReactions makes the stored reactions of every accepted emoji match Discord's. Reactions
+// that were already stored keep the time they were added, missed ones are dated to the message.
+func (b *Bot) syncReactions(s *discordgo.Session, m *discordgo.Message) (err error) {
+	emojis, err := b.acceptedEmojis(m.GuildID)
+	if err != nil {
+		return
+	}
+
+	anti := b.antiStarEmoji(m.GuildID)
+	if anti != nil {
+		emojis = append(emojis, anti)
+	}
+
+	reacted := make([]string, 0)
+
+	for _, r := range m.Reactions {
+		key := util.EmojiKey(r.Emoji)
+		if !hasEmoji(emojis, key) {
+			continue
 		}
 
-		c, err := s.State.Channel(m.ChannelID)
-		if err != nil {
-			return nil, err
+		kind := reactionStar
+		if anti != nil && anti.Key() == key {
+			kind = reactionAnti
 		}
 
-		msg = &tables.Message{
-			ID:        id,
-			AuthorID:  m.Author.ID,
-			Username: m.Author.Username,
-			Avatar: m.Author.AvatarURL(""),
-			ChannelID: m.ChannelID,
-			GuildID:   c.GuildID,
+		reacted = append(reacted, key)
 
-			Content: util.GetContent(m),
-			Image:   util.GetImage(m),
-		}
+		q := b.PG.Model((*tables.Reaction)(nil)).Where("message_id = ?", m.ID).Where("emoji = ?", key)
 
-		completeCount, err := b.countStars(msg, true)
+		count, err := q.Count()
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		emoji := b.Settings.GetEmoji(m.GuildID, settingEmoji)
-		for _, r := range m.Reactions {
-			if r.Emoji.ID == "" {
-				if r.Emoji.Name != emoji.Unicode {
-					continue
-				}
-			} else if r.Emoji.ID != emoji.ID {
-				continue
+		if count == r.Count {
+			continue
+		}
+
+		after := ""
+		reactions := make([]tables.Reaction, 0)
+		users := make([]string, 0)
+
+		for len(reactions) < r.Count {
+			page, err := s.MessageReactions(m.ChannelID, m.ID, r.Emoji.APIName(), 100, "", after)
+			if err != nil {
+				return err
 			}
 
-			if completeCount == r.Count {
+			if len(page) == 0 {
 				break
 			}
 
-			_, err = b.PG.Model((*tables.Reaction)(nil)).Where("message_id = ?", m.ID).Delete()
-			if err != nil && err != pg.ErrNoRows {
-				return nil, err
+			for _, u := range page {
+				users = append(users, u.ID)
+				reactions = append(reactions, tables.Reaction{
+					Bot:       u.Bot,
+					UserID:    u.ID,
+					MessageID: m.ID,
+					Emoji:     key,
+					GuildID:   m.GuildID,
+					ChannelID: m.ChannelID,
+					Weight:    b.memberWeight(s, m.GuildID, u.ID),
+					JoinedAt:  memberJoinedAt(s, m.GuildID, u.ID),
+					Kind:      kind,
+					// When a missed reaction was added isn't known, so it's dated to the
+					// message itself, which always falls inside the star window
+					CreatedAt: util.SnowflakeTimestamp(m.ID),
+				})
 			}
 
-			after := ""
-			reactions := make([]tables.Reaction, 0)
-
-			for len(reactions) < r.Count {
-				users, err := s.MessageReactions(m.ChannelID, m.ID, r.Emoji.APIName(), 100, "", after)
-				if err != nil {
-					return nil, err
-				}
+			after = page[len(page)-1].ID
+		}
 
-				for _, u := range users {
-					reactions = append(reactions, tables.Reaction{
-						Bot:       u.Bot,
-						UserID:    u.ID,
-						MessageID: m.ID,
-					})
-				}
+		if len(users) != 0 {
+			q = q.Where("user_id NOT IN (?)", pg.In(users))
+		}
 
-				if len(users) != 0 {
-					after = users[len(users)-1].ID
-				}
-			}
+		_, err = q.Delete()
+		if err != nil && err != pg.ErrNoRows {
+			return err
+		}
 
-			if len(reactions) != 0 {
-				_, err = b.PG.Model(&reactions).OnConflict("DO NOTHING").Insert()
-				if err != nil {
-					return nil, err
-				}
+		if len(reactions) != 0 {
+			_, err = b.PG.Model(&reactions).OnConflict("DO NOTHING").Insert()
+			if err != nil {
+				return err
 			}
-
-			break
 		}
+	}
 
-		go b.cacheMessage(m)
-	} else {
-		data := res.(*tables.Message)
+	// Emojis that were entirely removed don't show up in the message's reactions anymore
+	keys := make([]string, len(emojis))
+	for i, e := range emojis {
+		keys[i] = e.Key()
+	}
 
-		msg = &tables.Message{
-			ID:        id,
-			AuthorID:  data.AuthorID,
-			Username: data.Username,
-			Avatar: data.Avatar,
-			ChannelID: channel,
-			GuildID:   data.GuildID,
+	q := b.PG.Model((*tables.Reaction)(nil)).Where("message_id = ?", m.ID).Where("emoji IN (?)", pg.In(keys))
+	if len(reacted) != 0 {
+		q = q.Where("emoji NOT IN (?)", pg.In(reacted))
+	}
 
-			Content: data.Content,
-			Image: data.Image,
-		}
+	_, err = q.Delete()
+	if err == pg.ErrNoRows {
+		err = nil
 	}
 
 	return
 }
 
+// getReply gets the author and a snippet of the message a message replied to
+func getReply(m *discordgo.Message) *tables.Reply {
+	ref := m.ReferencedMessage
+	if ref == nil || ref.Author == nil {
+		return nil
+	}
+
+	return &tables.Reply{
+		ID:       ref.ID,
+		AuthorID: ref.Author.ID,
+		Username: ref.Author.Username,
+		Content:  truncate(strings.Join(strings.Fields(util.GetContent(ref)), " "), maxReplyLength),
+	}
+}
+
+// replyLine renders the reply context shown above a starred message's content
+func replyLine(msg *tables.Message, l func(string, ...interface{}) string) string {
+	if msg.Reply == nil {
+		return ""
+	}
+
+	link := messageLink(&tables.Message{ID: msg.Reply.ID, ChannelID: msg.ChannelID, GuildID: msg.GuildID})
+	return l("message.reply", link, msg.Reply.Username, msg.Reply.Content) + "\n"
+}
+
 func (b *Bot) cacheMessage(m *discordgo.Message) {
 	key := "messages:" + m.ID
 
 	b.Cache.Set(key, &tables.Message{
-		AuthorID: m.Author.ID,
-		Username: m.Author.Username,
-		Avatar: m.Author.AvatarURL(""),
+		AuthorID:  m.Author.ID,
+		Username:  m.Author.Username,
+		Avatar:    m.Author.AvatarURL(""),
 		ChannelID: m.ChannelID,
-		GuildID: m.GuildID,
-		Content: util.GetContent(m),
-		Image: util.GetImage(m),
+		GuildID:   m.GuildID,
+		Content:   util.GetContent(m),
+		Image:     util.GetImage(m),
+		Gallery:   util.GetGallery(m),
+		Reply:     getReply(m),
 	}, expiryTime)
 }
 
-func (b *Bot) createMessage(s *discordgo.Session, m *tables.Message) (err error) {
-	m, err = b.getMessage(s, m.ID, m.ChannelID)
-	if err != nil {
-		return
+// isBlocked checks whether a message can't be posted because of the guild's block or whitelist
+// entries. They match the message's author, its channel or thread parent and the author's roles.
+func (b *Bot) isBlocked(s *discordgo.Session, m *tables.Message) (bool, error) {
+	// Blocking a channel also blocks its threads
+	channels := []string{m.ChannelID}
+	if c, err := b.sourceChannel(s, m.ChannelID); err == nil && c.ID != m.ChannelID {
+		channels = append(channels, c.ID)
 	}
 
-	c, _ := b.PG.
+	var roles []string
+	if member, err := s.State.Member(m.GuildID, m.AuthorID); err == nil {
+		roles = member.Roles
+	}
+
+	c, err := b.PG.
 		Model((*tables.Block)(nil)).
 		Where("guild_id = ?", m.GuildID).
-		Where("type = 'user' AND id = ?", m.AuthorID).
-		WhereOr("type = 'channel' AND id = ?", m.ChannelID).
+		Where("expires_at IS NULL OR expires_at > now()").
+		WhereGroup(func(q *orm.Query) (*orm.Query, error) {
+			q = q.WhereOr("type = 'user' AND id = ?", m.AuthorID).
+				WhereOr("type = 'channel' AND id IN (?)", pg.In(channels))
+
+			if len(roles) != 0 {
+				q = q.WhereOr("type = 'role' AND id IN (?)", pg.In(roles))
+			}
+
+			return q, nil
+		}).
 		Count()
+	if err != nil {
+		return false, err
+	}
 
-	switch b.Settings.GetString(m.GuildID, settingBlockMode) {
-	case "blacklist":
-		if c != 0 {
-			return
-		}
+	if b.Settings.GetString(m.GuildID, settingBlockMode) == "whitelist" {
+		return c == 0, nil
+	}
 
-	case "whitelist":
-		if c == 0 {
-			return
-		}
+	return c != 0, nil
+}
+
+func (b *Bot) createMessage(s *discordgo.Session, m *tables.Message) (err error) {
+	m, err = b.getMessage(s, m.ID, m.ChannelID)
+	if err != nil {
+		return
 	}
 
-	starboard := b.getStarboard(s, m.ChannelID, m.GuildID)
-	if starboard == settingNone {
+	if days := b.Settings.GetInt(m.GuildID, settingMaxAge); days != 0 && time.Since(util.SnowflakeTimestamp(m.ID)) > time.Duration(days)*24*time.Hour {
 		return
 	}
 
-	count, err := b.countStars(m, false)
-	if err != nil {
+	blocked, err := b.isBlocked(s, m)
+	if err != nil || blocked {
 		return
 	}
 
-	if count < b.Settings.GetInt(m.GuildID, settingMinimum) {
+	m.SentIDs = make(map[string]string)
+
+	err = b.updatePosts(s, m)
+	if err != nil || len(m.SentIDs) == 0 {
 		return
 	}
 
-	sent, err := s.ChannelMessageSendEmbed(starboard, b.generateEmbed(m, count))
+	err = b.PG.Insert(m)
+	return
+}
+
+// countStars sums the weights of the users who starred a message on a board. Users
+// who reacted with several of the board's emojis are only counted once.
+func (b *Bot) countStars(m *tables.Message, board *tables.Board) (int, error) {
+	var keys []string
+	for _, e := range boardEmojis(board) {
+		keys = append(keys, e.Key())
+	}
+
+	return b.countReactions(m, reactionStar, keys)
+}
+
+// countAntiStars sums the weights of the users who anti-starred a message
+func (b *Bot) countAntiStars(m *tables.Message) (int, error) {
+	e := b.antiStarEmoji(m.GuildID)
+	if e == nil {
+		return 0, nil
+	}
+
+	return b.countReactions(m, reactionAnti, []string{e.Key()})
+}
+
+// reactionsQuery builds a query of the reactions of a kind on a message, one row per user,
+// leaving out those that never count. Reactions of users who are too new are left in.
+func (b *Bot) reactionsQuery(m *tables.Message, kind string) *orm.Query {
+	q := b.PG.Model((*tables.Reaction)(nil)).
+		Where("message_id = ?", m.ID).
+		Where("kind = ?", kind).
+		Where("ignored = FALSE").
+		Group("user_id")
+
+	if !b.Settings.GetBool(m.GuildID, settingSelfStar) {
+		q = q.Where("user_id != ?", m.AuthorID)
+	}
+
+	if b.Settings.GetBool(m.GuildID, settingRemoveBotStars) {
+		q = q.Where("bot = FALSE")
+	}
+
+	if hours := b.Settings.GetInt(m.GuildID, settingStarWindow); hours != 0 {
+		q = q.Where("created_at <= ?", util.SnowflakeTimestamp(m.ID).Add(time.Duration(hours)*time.Hour))
+	}
+
+	return q
+}
+
+func (b *Bot) countReactions(m *tables.Message, kind string, keys []string) (count int, err error) {
+	q := b.reactionsQuery(m, kind).
+		ColumnExpr("MAX(weight) AS weight").
+		Where("emoji IN (?)", pg.In(keys))
+
+	if days := b.Settings.GetInt(m.GuildID, settingMinAccountAge); days != 0 {
+		q = q.Where(accountCreatedAt+" <= created_at - ? * interval '1 day'", days)
+	}
+
+	if hours := b.Settings.GetInt(m.GuildID, settingMinMemberAge); hours != 0 {
+		q = q.Where("(joined_at IS NULL OR joined_at <= created_at - ? * interval '1 hour')", hours)
+	}
+
+	_, err = b.PG.QueryOne(pg.Scan(&count), "SELECT COALESCE(SUM(weight), 0) FROM (?) AS stars", q)
+	return
+}
+
+// guildStars builds a query of the stars given in a guild, one row per starrer and message,
+// following the same rules as countStars. Callers can narrow it down by the columns of
+// reaction and message, then sum its weight column.
+func (b *Bot) guildStars(guildID string) *orm.Query {
+	q := b.PG.Model((*tables.Reaction)(nil)).
+		ColumnExpr("reaction.user_id, reaction.message_id, message.author_id, message.channel_id, MAX(reaction.weight) AS weight").
+		Join("JOIN messages AS message ON message.id = reaction.message_id").
+		Where("reaction.guild_id = ?", guildID).
+		Where("reaction.kind = ?", reactionStar).
+		Where("reaction.ignored = FALSE").
+		Group("reaction.user_id", "reaction.message_id", "message.author_id", "message.channel_id")
+
+	if !b.Settings.GetBool(guildID, settingSelfStar) {
+		q = q.Where("reaction.user_id != message.author_id")
+	}
+
+	if b.Settings.GetBool(guildID, settingRemoveBotStars) {
+		q = q.Where("reaction.bot = FALSE")
+	}
+
+	if hours := b.Settings.GetInt(guildID, settingStarWindow); hours != 0 {
+		q = q.Where("reaction.created_at <= "+messageCreatedAt+" + ? * interval '1 hour'", hours)
+	}
+
+	if days := b.Settings.GetInt(guildID, settingMinAccountAge); days != 0 {
+		q = q.Where(accountCreatedAt+" <= reaction.created_at - ? * interval '1 day'", days)
+	}
+
+	if hours := b.Settings.GetInt(guildID, settingMinMemberAge); hours != 0 {
+		q = q.Where("(reaction.joined_at IS NULL OR reaction.joined_at <= reaction.created_at - ? * interval '1 hour')", hours)
+	}
+
+	return q
+}
+
+// memberWeight gets how much a member's stars count. The weight of their highest role
+// with one takes precedence over the guild's default weight.
+func (b *Bot) memberWeight(s *discordgo.Session, guildID, userID string) int {
+	weight := b.Settings.GetInt(guildID, settingWeight)
+
+	member, err := s.State.Member(guildID, userID)
 	if err != nil {
+		return weight
+	}
+
+	position := -1
+	for _, id := range member.Roles {
+		v, ok := b.Settings.Lookup(id, settingWeight)
+		if !ok {
+			continue
+		}
+
+		if role, err := s.State.Role(guildID, id); err == nil && role.Position > position {
+			weight, position = v.(int), role.Position
+		}
+	}
+
+	return weight
+}
+
+// overBudget checks whether starring a message would exceed the number of distinct
+// messages a user can star in a guild per rolling 24 hours
+func (b *Bot) overBudget(guildID, userID, messageID string) (over bool, err error) {
+	budget := b.Settings.GetInt(guildID, settingStarBudget)
+	if budget == 0 {
 		return
 	}
 
-	m.SentID = sent.ID
-	err = b.PG.Insert(m)
+	q := b.PG.Model((*tables.Reaction)(nil)).
+		Where("guild_id = ?", guildID).
+		Where("user_id = ?", userID).
+		Where("kind = ?", reactionStar).
+		Where("ignored = FALSE")
+
+	// Starring a message again with another emoji doesn't use up the budget
+	starred, err := q.Copy().Where("message_id = ?", messageID).Exists()
+	if err != nil || starred {
+		return
+	}
+
+	var count int
+	err = q.ColumnExpr("COUNT(DISTINCT message_id)").
+		Where("created_at > ?", time.Now().Add(-24*time.Hour)).
+		Select(pg.Scan(&count))
+
+	return count >= budget, err
+}
+
+// memberJoinedAt gets when a member joined a guild, or the zero time if it's unknown
+func memberJoinedAt(s *discordgo.Session, guildID, userID string) time.Time {
+	member, err := s.State.Member(guildID, userID)
+	if err != nil {
+		return time.Time{}
+	}
+
+	t, _ := member.JoinedAt.Parse()
+	return t
+}
+
+// ignoredStars counts the users whose stars on a message are ignored because their
+// account was too young or because they had only just joined the guild. Stars that
+// wouldn't count anyway, such as self-stars, aren't included.
+func (b *Bot) ignoredStars(m *tables.Message) (young, fresh int, err error) {
+	if days := b.Settings.GetInt(m.GuildID, settingMinAccountAge); days != 0 {
+		q := b.reactionsQuery(m, reactionStar).
+			Column("user_id").
+			Where(accountCreatedAt+" > created_at - ? * interval '1 day'", days)
+
+		_, err = b.PG.QueryOne(pg.Scan(&young), "SELECT COUNT(*) FROM (?) AS stars", q)
+		if err != nil {
+			return
+		}
+	}
+
+	if hours := b.Settings.GetInt(m.GuildID, settingMinMemberAge); hours != 0 {
+		q := b.reactionsQuery(m, reactionStar).
+			Column("user_id").
+			Where("joined_at > created_at - ? * interval '1 hour'", hours)
+
+		_, err = b.PG.QueryOne(pg.Scan(&fresh), "SELECT COUNT(*) FROM (?) AS stars", q)
+	}
+
+	return
+}
+
+// weightedRoles lists the roles with a weight of their own among roles, in a stable order
+func (b *Bot) weightedRoles(roles []string) string {
+	var weighted []string
+	for _, id := range roles {
+		if _, ok := b.Settings.Lookup(id, settingWeight); ok {
+			weighted = append(weighted, id)
+		}
+	}
+
+	sort.Strings(weighted)
+	return strings.Join(weighted, ",")
+}
+
+// updateWeight recomputes the weight of the stars a member gave in a guild, returning
+// the messages whose stars changed
+func (b *Bot) updateWeight(s *discordgo.Session, guildID, userID string) (ids []string, err error) {
+	weight := b.memberWeight(s, guildID, userID)
+
+	_, err = b.PG.Query(&ids, `
+	UPDATE reactions SET weight = ?
+	WHERE guild_id = ? AND user_id = ? AND weight != ?
+	RETURNING message_id
+	`, weight, guildID, userID, weight)
 	return
 }
 
-func (b *Bot) countStars(m *tables.Message, raw bool) (int, error) {
-	q := b.PG.Model((*tables.Reaction)(nil)).Where("message_id = ?", m.ID)
+// updateRoleWeights recomputes the weight of the stars given by the members of roles
+func (b *Bot) updateRoleWeights(s *discordgo.Session, guildID string, roles []*discordgo.Role) {
+	g, err := s.State.Guild(guildID)
+	if err != nil {
+		return
+	}
+
+	var updated []string
+	for _, member := range g.Members {
+		for _, r := range roles {
+			if hasString(member.Roles, r.ID) {
+				ids, err := b.updateWeight(s, guildID, member.User.ID)
+				b.reportError(err, map[string]string{"guild": guildID})
 
-	if !raw {
-		if !b.Settings.GetBool(m.GuildID, settingSelfStar) {
-			q = q.Where("user_id != ?", m.AuthorID)
+				updated = append(updated, ids...)
+				break
+			}
 		}
+	}
+
+	b.refreshMessages(s, updated)
+}
 
-		if b.Settings.GetBool(m.GuildID, settingRemoveBotStars) {
-			q = q.Where("bot = FALSE")
+// updateDefaultWeight recomputes the weight of the stars given by members without a weighted role,
+// including those who left the guild
+func (b *Bot) updateDefaultWeight(s *discordgo.Session, guildID string) {
+	weight := b.Settings.GetInt(guildID, settingWeight)
+
+	// Members with a weighted role keep its weight
+	weighted := []string{}
+	if g, err := s.State.Guild(guildID); err == nil {
+		for _, member := range g.Members {
+			for _, id := range member.Roles {
+				if _, ok := b.Settings.Lookup(id, settingWeight); ok {
+					weighted = append(weighted, member.User.ID)
+					break
+				}
+			}
 		}
 	}
 
-	return q.Count()
+	var reactions []*tables.Reaction
+	_, err := b.PG.Query(&reactions, `
+	UPDATE reactions SET weight = ?0
+	WHERE guild_id = ?1 AND weight != ?0 AND user_id <> ALL(?2)
+	RETURNING user_id, message_id
+	`, weight, guildID, pg.Array(weighted))
+	if err != nil {
+		b.reportError(err, map[string]string{"guild": guildID})
+		return
+	}
+
+	var ids []string
+	for _, r := range reactions {
+		ids = append(ids, r.MessageID)
+
+		// Members who left aren't known to have a weighted role anymore if they come back
+		b.Cache.Delete("weighted:" + guildID + ":" + r.UserID)
+	}
+
+	b.refreshMessages(s, ids)
+}
+
+// refreshMessages updates the starboard posts of the recent tracked messages among ids,
+// after something other than a reaction changed their count
+func (b *Bot) refreshMessages(s *discordgo.Session, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+
+	var msgs []*tables.Message
+	err := b.PG.Model(&msgs).
+		Column("id", "channel_id", "guild_id").
+		Where("id IN (?)", pg.In(ids)).
+		Where("id::bigint > ?", util.TimestampSnowflake(time.Now().Add(-reconcileWindow))).
+		Where("deleted_at IS NULL").
+		Select()
+	if err != nil {
+		b.reportError(err, map[string]string{"task": "refresh"})
+		return
+	}
+
+	for _, msg := range msgs {
+		b.mutexGroup.Lock(msg.ID)
+		err = b.updateMessage(s, &tables.Message{
+			ID:        msg.ID,
+			ChannelID: msg.ChannelID,
+			GuildID:   msg.GuildID,
+		})
+		b.mutexGroup.Unlock(msg.ID)
+
+		// Discord refusing a single message, for example because the bot lost access to the
+		// starboard, shouldn't stop the others from being updated
+		if restErrorCode(err) == 0 {
+			b.reportError(err, map[string]string{"task": "refresh", "message": msg.ID})
+		}
+	}
 }
 
 func (b *Bot) updateMessage(s *discordgo.Session, m *tables.Message) (err error) {
@@ -315,41 +969,123 @@ func (b *Bot) updateMessage(s *discordgo.Session, m *tables.Message) (err error)
 		return
 	}
 
-	count, err := b.countStars(m, false)
+	if m.SentIDs == nil {
+		m.SentIDs = make(map[string]string)
+	}
+
+	err = b.updatePosts(s, m)
 	if err != nil {
 		return
 	}
 
-	starboard := b.getStarboard(s, m.ChannelID, m.GuildID)
-	if starboard == settingNone {
+	if len(m.SentIDs) == 0 {
+		go b.PG.Delete(m)
 		return
 	}
 
-	if count < b.Settings.GetInt(m.GuildID, settingMinimum) {
-		go s.ChannelMessageDelete(starboard, m.SentID)
-		go b.PG.Delete(m)
+	_, err = b.PG.Model(m).Column("sent_ids", "actions").WherePK().Update()
+	return
+}
+
+// markDeleted records that the original of a message was deleted and edits its posts to show it
+func (b *Bot) markDeleted(s *discordgo.Session, m *tables.Message) (err error) {
+	if !m.DeletedAt.IsZero() {
+		return
+	}
+
+	m.DeletedAt = time.Now()
+	if m.SentIDs == nil {
+		m.SentIDs = make(map[string]string)
+	}
+
+	err = b.updatePosts(s, m)
+	if err != nil {
 		return
 	}
 
-	embed := b.generateEmbed(m, count)
-	_, err = s.ChannelMessageEditEmbed(starboard, m.SentID, embed)
-	if err == nil {
+	if len(m.SentIDs) == 0 {
+		_, err = b.PG.Model(m).WherePK().Delete()
 		return
 	}
 
-	if rErr, ok := err.(*discordgo.RESTError); ok && rErr.Message != nil {
-		if rErr.Message.Code != discordgo.ErrCodeUnknownMessage {
-			return
+	_, err = b.PG.Model(m).Column("sent_ids", "actions", "deleted_at").WherePK().Update()
+	return
+}
+
+// updatePosts evaluates a message against every board it belongs to, posting,
+// editing or deleting its starboard posts and recording them in m.SentIDs
+func (b *Bot) updatePosts(s *discordgo.Session, m *tables.Message) (err error) {
+	boards, err := b.getStarboards(s, m.ChannelID, m.GuildID)
+	if err != nil {
+		return
+	}
+
+	anti, err := b.countAntiStars(m)
+	if err != nil {
+		return
+	}
+
+	// Posts with enough anti-stars are voted off every board, whatever their star count
+	removal := b.Settings.GetInt(m.GuildID, settingAntiStarRemoval)
+	removed := removal != 0 && anti >= removal
+
+	best := 0
+
+	for _, board := range boards {
+		count, err := b.countStars(m, board)
+		if err != nil {
+			return err
+		}
+
+		count -= anti
+		if count > best {
+			best = count
+		}
+
+		sentID, posted := m.SentIDs[board.Name]
+
+		if count < board.Minimum || removed {
+			if posted {
+				go b.deletePost(s, board.ChannelID, m.GuildID, sentID)
+				delete(m.SentIDs, board.Name)
+			}
+
+			continue
 		}
 
-		sent, err := s.ChannelMessageSendEmbed(starboard, embed)
+		embeds := b.generateEmbeds(m, board, count, anti)
+
+		if posted {
+			err = b.editPost(s, board.ChannelID, m, sentID, embeds)
+			if err == nil {
+				continue
+			}
+
+			if !isUnknownPost(err) {
+				return err
+			}
+		}
+
+		sentID, err = b.sendPost(s, board.ChannelID, m, embeds)
 		if err != nil {
 			return err
 		}
 
-		_, err = b.PG.Model(&tables.Message{ID: m.ID, SentID: sent.ID}).WherePK().UpdateNotNull()
-		return err
+		m.SentIDs[board.Name] = sentID
+	}
+
+	if len(m.SentIDs) != 0 {
+		b.runActions(s, m, best)
 	}
 
 	return
 }
+
+// deletePosts deletes every starboard post of a message
+func (b *Bot) deletePosts(s *discordgo.Session, msg *tables.Message) {
+	for name, sentID := range msg.SentIDs {
+		if channel := b.getBoardChannel(s, msg, name); channel != settingNone {
+			b.deletePost(s, channel, msg.GuildID, sentID)
+		}
+	}
+}
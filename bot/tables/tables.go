@@ -1,5 +1,11 @@
 package tables
 
+import (
+	"time"
+
+	"github.com/dbhq/starboard/bot/util"
+)
+
 // Message represents a Discord message
 type Message struct {
 	ID        string `sql:",pk"`
@@ -8,10 +14,38 @@ type Message struct {
 	Avatar    string
 	ChannelID string
 	GuildID   string
-	SentID    string
+	SentIDs   map[string]string
 
 	Content string
 	Image   string
+	Gallery []string
+	Reply   *Reply
+
+	// Actions holds the keys of the threshold actions already performed for the message
+	Actions []string
+
+	// DeletedAt is when the original message was deleted, if its posts were kept
+	DeletedAt time.Time
+	// EditedAt is when the original message was last edited
+	EditedAt time.Time
+}
+
+// Reply represents the message a Discord message replied to
+type Reply struct {
+	ID       string
+	AuthorID string
+	Username string
+	Content  string
+}
+
+// Revision represents a version of a tracked message's content. The first one
+// is the content the message had when the bot started tracking it.
+type Revision struct {
+	MessageID string    `sql:",pk"`
+	EditedAt  time.Time `sql:",pk"`
+	GuildID   string
+	Content   string
+	Image     string
 }
 
 // Reaction represents a Discord reaction
@@ -19,11 +53,51 @@ type Reaction struct {
 	Bot       bool   `sql:",notnull"`
 	UserID    string `sql:",pk"`
 	MessageID string `sql:",pk"`
+	Emoji     string `sql:",pk"`
+	GuildID   string `sql:",notnull"`
+	ChannelID string
+	Weight    int       `sql:",notnull"`
+	CreatedAt time.Time `sql:",notnull,default:now()"`
+	JoinedAt  time.Time
+	Ignored   bool   `sql:",notnull"`
+	Kind      string `sql:",notnull"`
 }
 
 // Block represents a blocker user/channel/role
 type Block struct {
-	ID      string `sql:",pk"`
-	GuildID string `sql:",pk"`
-	Type    string
+	ID          string `sql:",pk"`
+	GuildID     string `sql:",pk"`
+	Type        string
+	ModeratorID string
+	Reason      string
+	ExpiresAt   time.Time // zero for permanent blocks
+}
+
+// Action represents something done when a message reaches a star count
+type Action struct {
+	GuildID   string `sql:",pk"`
+	Threshold int    `sql:",pk"`
+	Type      string `sql:",pk"`
+	RoleID    string
+	Duration  time.Duration `sql:",notnull"`
+}
+
+// TimedRole represents a role granted by an action that must be removed later
+type TimedRole struct {
+	GuildID   string `sql:",pk"`
+	UserID    string `sql:",pk"`
+	RoleID    string `sql:",pk"`
+	ExpiresAt time.Time
+}
+
+// Board represents a named starboard
+type Board struct {
+	Name      string `sql:",pk"`
+	GuildID   string `sql:",pk"`
+	ChannelID string
+	Emoji     *util.Emoji
+	Emojis    []*util.Emoji // accepted in addition to Emoji
+	Minimum   int           `sql:",notnull"`
+	NSFW      bool          `sql:",notnull"`
+	Channels  []string
 }
@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dbhq/discordgo"
+	"github.com/dbhq/starboard/bot/commandler"
+	"github.com/dbhq/starboard/bot/tables"
+	"github.com/dbhq/starboard/bot/util"
+)
+
+const maxTemplateLength = 1500
+
+// defaultTemplate mirrors the built-in embed layout
+const defaultTemplate = `author: {username}
+description: {reply}{content}
+field: Author | {author}
+field: Channel | {channel} [(Jump)]({jump}){deleted}
+footer: {emoji} {count}
+timestamp
+image`
+
+var rePlaceholder = regexp.MustCompile(`{(\w*)}`)
+
+var reEmptyLink = regexp.MustCompile(`\[[^\]]*\]\(\)`)
+
+var templatePlaceholders = map[string]bool{
+	"author":    true,
+	"username":  true,
+	"channel":   true,
+	"jump":      true,
+	"count":     true,
+	"anti":      true,
+	"emoji":     true,
+	"timestamp": true,
+	"content":   true,
+	"reply":     true,
+	"tier":      true,
+	"deleted":   true,
+	"edited":    true,
+}
+
+// embedTemplate represents a guild's starboard embed layout
+type embedTemplate struct {
+	Author      string
+	Title       string
+	Description string
+	Fields      [][2]string
+	Footer      string
+	Timestamp   bool
+	Image       bool
+}
+
+// templateError represents a template that failed validation
+type templateError struct {
+	Line  int
+	Code  string
+	Value string
+}
+
+func (e *templateError) Error() string {
+	return fmt.Sprintf("template line %d: %s %s", e.Line, e.Code, e.Value)
+}
+
+// parseTemplate parses and validates a template, one "key: value" pair per line
+func parseTemplate(str string) (t *embedTemplate, err error) {
+	if len(str) > maxTemplateLength {
+		return nil, &templateError{0, "too_long", strconv.Itoa(maxTemplateLength)}
+	}
+
+	t = &embedTemplate{}
+
+	for i, line := range strings.Split(str, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		key, value := line, ""
+		if idx := strings.Index(line, ":"); idx != -1 {
+			key, value = strings.TrimSpace(line[:idx]), strings.TrimSpace(line[idx+1:])
+		}
+
+		for _, match := range rePlaceholder.FindAllStringSubmatch(value, -1) {
+			if !templatePlaceholders[match[1]] {
+				return nil, &templateError{i + 1, "unknown_placeholder", match[0]}
+			}
+		}
+
+		switch strings.ToLower(key) {
+		case "author":
+			t.Author = value
+		case "title":
+			t.Title = value
+		case "description":
+			t.Description = value
+		case "field":
+			parts := strings.SplitN(value, "|", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || strings.TrimSpace(parts[1]) == "" {
+				return nil, &templateError{i + 1, "field", value}
+			}
+
+			if len(t.Fields) == 25 {
+				return nil, &templateError{i + 1, "too_many_fields", "25"}
+			}
+
+			t.Fields = append(t.Fields, [2]string{strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])})
+		case "footer":
+			t.Footer = value
+		case "timestamp":
+			t.Timestamp = true
+		case "image":
+			t.Image = true
+		default:
+			return nil, &templateError{i + 1, "unknown_key", key}
+		}
+	}
+
+	if t.Author == "" && t.Title == "" && t.Description == "" && len(t.Fields) == 0 {
+		return nil, &templateError{0, "empty", ""}
+	}
+
+	return
+}
+
+func (b *Bot) renderTemplate(t *embedTemplate, msg *tables.Message, board *tables.Board, count, anti int) (embed *discordgo.MessageEmbed) {
+	emoji := board.Emoji
+	tier := b.getTier(msg.GuildID, count)
+	timestamp := util.SnowflakeTimestamp(msg.ID)
+	l := b.Locales.Language(b.Settings.GetString(msg.GuildID, settingLanguage))
+
+	label := ""
+	if tier != nil {
+		label = tier.Label
+
+		if tier.Emoji != nil {
+			emoji = tier.Emoji
+		}
+	}
+
+	replace := templateReplacer(msg, emoji, label, count, anti, l)
+
+	embed = &discordgo.MessageEmbed{
+		Color:       gray,
+		Title:       truncate(replace(t.Title), 256),
+		Description: truncate(replace(t.Description), 2048),
+	}
+
+	if tier != nil && !b.Settings.GetBool(msg.GuildID, settingMinimal) {
+		embed.Color = tier.Color
+	}
+
+	if t.Author != "" {
+		embed.Author = &discordgo.MessageEmbedAuthor{
+			Name:    truncate(replace(t.Author), 256),
+			IconURL: msg.Avatar,
+		}
+	}
+
+	for _, field := range t.Fields {
+		embed.Fields = append(embed.Fields, &discordgo.MessageEmbedField{
+			Name:   truncate(replace(field[0]), 256),
+			Value:  truncate(replace(field[1]), 1024),
+			Inline: true,
+		})
+	}
+
+	if t.Footer != "" {
+		footer := &discordgo.MessageEmbedFooter{}
+
+		// Footers can't render custom emojis, so they're shown as the icon instead
+		if emoji.ID != "" && strings.Contains(t.Footer, "{emoji}") {
+			footer.IconURL = emoji.URL()
+			footer.Text = strings.TrimSpace(replace(strings.Replace(t.Footer, "{emoji}", "", -1)))
+		} else {
+			footer.Text = replace(t.Footer)
+		}
+
+		footer.Text = truncate(footer.Text, 2048)
+		embed.Footer = footer
+	}
+
+	if t.Timestamp {
+		embed.Timestamp = timestamp.Format(time.RFC3339)
+	}
+
+	if t.Image && msg.Image != "" {
+		embed.Image = &discordgo.MessageEmbedImage{
+			URL: msg.Image,
+		}
+	}
+
+	return
+}
+
+// templateReplacer creates a function that replaces the placeholders of a template with the values of a message
+func templateReplacer(msg *tables.Message, emoji *util.Emoji, label string, count, anti int, l func(string, ...interface{}) string) func(string) string {
+	// The jump link of a deleted message would lead nowhere
+	jump := messageLink(msg)
+	deleted := ""
+	if !msg.DeletedAt.IsZero() {
+		jump = ""
+		deleted = l("message.deleted")
+	}
+
+	edited := ""
+	if !msg.EditedAt.IsZero() {
+		edited = l("message.edited")
+	}
+
+	replacer := strings.NewReplacer(
+		"{author}", "<@"+msg.AuthorID+">",
+		"{username}", msg.Username,
+		"{channel}", "<#"+msg.ChannelID+">",
+		"{jump}", jump,
+		"{count}", strconv.Itoa(count),
+		"{anti}", strconv.Itoa(anti),
+		"{emoji}", emoji.String(),
+		"{timestamp}", util.SnowflakeTimestamp(msg.ID).UTC().Format("2006-01-02 15:04 UTC"),
+		"{content}", msg.Content,
+		"{reply}", replyLine(msg, l),
+		"{tier}", label,
+		"{deleted}", deleted,
+		"{edited}", edited,
+	)
+
+	return func(str string) string {
+		// Links left without a target, such as the jump link of a deleted message, are dropped
+		return reEmptyLink.ReplaceAllString(replacer.Replace(str), "")
+	}
+}
+
+// templateArg joins the arguments of a command back into a template, removing any surrounding code block
+func templateArg(args []string) string {
+	str := strings.TrimSpace(strings.Join(args, " "))
+	str = strings.TrimPrefix(str, "```")
+	str = strings.TrimSuffix(str, "```")
+
+	return strings.TrimSpace(str)
+}
+
+func sayTemplateError(ctx *commandler.Context, err error) {
+	tErr, ok := err.(*templateError)
+	if !ok {
+		return
+	}
+
+	var reason string
+	if tErr.Value == "" {
+		reason = ctx.S("commands.template.error." + tErr.Code)
+	} else {
+		reason = ctx.S("commands.template.error."+tErr.Code, tErr.Value)
+	}
+
+	if tErr.Line == 0 {
+		ctx.Say("commands.template.phrase.invalid", reason)
+	} else {
+		ctx.Say("commands.template.phrase.invalid_line", tErr.Line, reason)
+	}
+}
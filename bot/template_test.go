@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dbhq/starboard/bot/tables"
+	"github.com/dbhq/starboard/bot/util"
+)
+
+func testLocale(key string, values ...interface{}) string {
+	switch key {
+	case "message.deleted":
+		return "(Deleted)"
+	case "message.edited":
+		return "edited"
+	}
+
+	return fmt.Sprint(append([]interface{}{key}, values...)...)
+}
+
+func TestTemplateDeletedAndEdited(t *testing.T) {
+	tmpl, err := parseTemplate("description: {content}\nfield: Channel | {channel} [(Jump)]({jump}){deleted}\nfooter: {count} {edited}")
+	if err != nil {
+		t.Fatalf("parseTemplate: %v", err)
+	}
+
+	msg := &tables.Message{
+		ID:        "500000000000000000",
+		ChannelID: "400000000000000000",
+		GuildID:   "300000000000000000",
+		Content:   "hello",
+	}
+	emoji := &util.Emoji{Name: "star", Unicode: starEmoji}
+
+	replace := templateReplacer(msg, emoji, "", 3, 0, testLocale)
+
+	if got := replace(tmpl.Fields[0][1]); !strings.Contains(got, messageLink(msg)) || strings.Contains(got, "(Deleted)") {
+		t.Errorf("field of a live message = %q", got)
+	}
+
+	if got := replace(tmpl.Footer); got != "3 " {
+		t.Errorf("footer of an unedited message = %q", got)
+	}
+
+	msg.DeletedAt = time.Now()
+	msg.EditedAt = time.Now()
+	replace = templateReplacer(msg, emoji, "", 3, 0, testLocale)
+
+	if got, want := replace(tmpl.Fields[0][1]), "<#400000000000000000> (Deleted)"; got != want {
+		t.Errorf("field of a deleted message = %q, want %q", got, want)
+	}
+
+	if got, want := replace(tmpl.Footer), "3 edited"; got != want {
+		t.Errorf("footer of an edited message = %q, want %q", got, want)
+	}
+}
+
+func TestTemplateUnknownPlaceholder(t *testing.T) {
+	_, err := parseTemplate("description: {nope}")
+
+	tErr, ok := err.(*templateError)
+	if !ok || tErr.Code != "unknown_placeholder" {
+		t.Errorf("parseTemplate error = %v, want unknown_placeholder", err)
+	}
+}
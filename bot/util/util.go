@@ -2,6 +2,7 @@ package util
 
 import (
 	"encoding/base64"
+	"errors"
 	"path"
 	"strconv"
 	"strings"
@@ -25,6 +26,14 @@ type Emoji struct {
 	Animated bool   `json:"animated"`
 }
 
+// Tier represents a star count from which starboard posts change their look
+type Tier struct {
+	Threshold int    `json:"threshold"`
+	Color     int    `json:"color"`
+	Emoji     *Emoji `json:"emoji,omitempty"`
+	Label     string `json:"label,omitempty"`
+}
+
 // String returns the emoji as a Discord string
 func (e *Emoji) String() string {
 	if e.Unicode != "" {
@@ -66,6 +75,24 @@ func (e *Emoji) API() string {
 	return e.Name + ":" + e.ID
 }
 
+// Key returns the emoji's identifier used for storing reactions
+func (e *Emoji) Key() string {
+	if e.ID != "" {
+		return e.ID
+	}
+
+	return e.Unicode
+}
+
+// EmojiKey returns the identifier used for storing reactions of a Discord emoji
+func EmojiKey(e *discordgo.Emoji) string {
+	if e.ID != "" {
+		return e.ID
+	}
+
+	return e.Name
+}
+
 // EscapeMarkdown escapes Discord markdown
 func EscapeMarkdown(str string) string {
 	return mdReplacer.Replace(str)
@@ -129,32 +156,57 @@ func GetMissing(have, want int, l func(string, ...interface{}) string) string {
 	return perms + "```"
 }
 
-// GetImage gets the image attached to a message
-func GetImage(m *discordgo.Message) string {
+// GetImages gets every image attached to or embedded in a message
+func GetImages(m *discordgo.Message) (images []string) {
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if !seen[url] {
+			seen[url] = true
+			images = append(images, url)
+		}
+	}
+
 	for _, a := range m.Attachments {
 		if a.Width != 0 && isEmbeddable(a.Filename) {
-			return a.URL
+			add(a.URL)
 		}
 	}
 
 	for _, e := range m.Embeds {
 		switch e.Type {
 		case "image":
-			return e.URL
+			add(e.URL)
 
 		case "rich":
 			if e.Image != nil && e.Image.Width != 0 {
-				return e.Image.URL
-			}
-			if e.Thumbnail != nil && e.Thumbnail.Width != 0 {
-				return e.Thumbnail.URL
+				add(e.Image.URL)
+			} else if e.Thumbnail != nil && e.Thumbnail.Width != 0 {
+				add(e.Thumbnail.URL)
 			}
 		}
 	}
 
+	return
+}
+
+// GetImage gets the first image attached to a message
+func GetImage(m *discordgo.Message) string {
+	if images := GetImages(m); len(images) != 0 {
+		return images[0]
+	}
+
 	return ""
 }
 
+// GetGallery gets the images attached to a message after the first one
+func GetGallery(m *discordgo.Message) []string {
+	if images := GetImages(m); len(images) > 1 {
+		return images[1:]
+	}
+
+	return nil
+}
+
 // GetContent gets the content of a message
 func GetContent(m *discordgo.Message) (content string) {
 	for _, e := range m.Embeds {
@@ -216,6 +268,75 @@ func SnowflakeTimestamp(snowflake string) time.Time {
 	return time.Unix(0, ((id>>snowflakeTimestampShift)+discordSnowflakeEpoch)*int64(time.Millisecond))
 }
 
+// TimestampSnowflake gets the smallest Discord snowflake created at a time
+func TimestampSnowflake(t time.Time) string {
+	ms := t.UnixNano()/int64(time.Millisecond) - discordSnowflakeEpoch
+	if ms < 0 {
+		ms = 0
+	}
+
+	return strconv.FormatInt(ms<<snowflakeTimestampShift, 10)
+}
+
+var durationUnits = [...]struct {
+	suffix string
+	unit   time.Duration
+}{
+	{"w", 7 * 24 * time.Hour},
+	{"d", 24 * time.Hour},
+	{"h", time.Hour},
+	{"m", time.Minute},
+}
+
+// MaxDuration is the longest duration ParseDuration accepts, which keeps it far from overflowing
+const MaxDuration = 3650 * 24 * time.Hour
+
+var (
+	// ErrInvalidDuration is returned when a string isn't a duration
+	ErrInvalidDuration = errors.New("invalid duration")
+	// ErrDurationTooLong is returned when a duration is longer than MaxDuration
+	ErrDurationTooLong = errors.New("duration too long")
+)
+
+// ParseDuration parses a duration such as 30m, 12h, 7d or 2w
+func ParseDuration(str string) (time.Duration, error) {
+	str = strings.ToLower(strings.TrimSpace(str))
+
+	for _, u := range durationUnits {
+		if !strings.HasSuffix(str, u.suffix) {
+			continue
+		}
+
+		i, err := strconv.ParseInt(strings.TrimSuffix(str, u.suffix), 10, 64)
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange && i > 0 {
+			return 0, ErrDurationTooLong
+		}
+
+		if err != nil || i <= 0 {
+			return 0, ErrInvalidDuration
+		}
+
+		if i > int64(MaxDuration/u.unit) {
+			return 0, ErrDurationTooLong
+		}
+
+		return time.Duration(i) * u.unit, nil
+	}
+
+	return 0, ErrInvalidDuration
+}
+
+// FormatDuration formats a duration in the largest unit it is a multiple of
+func FormatDuration(d time.Duration) string {
+	for _, u := range durationUnits {
+		if d >= u.unit && d%u.unit == 0 {
+			return strconv.FormatInt(int64(d/u.unit), 10) + u.suffix
+		}
+	}
+
+	return d.String()
+}
+
 func isEmbeddable(filename string) bool {
 	switch strings.ToLower(path.Ext(filename)) {
 	case ".png", ".jpg", ".jpeg", ".gif", ".webp":
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	for _, test := range []struct {
+		str  string
+		want time.Duration
+		err  error
+	}{
+		{"30m", 30 * time.Minute, nil},
+		{"12h", 12 * time.Hour, nil},
+		{"7D", 7 * 24 * time.Hour, nil},
+		{"2w", 14 * 24 * time.Hour, nil},
+		{"3650d", MaxDuration, nil},
+		{"3651d", 0, ErrDurationTooLong},
+		{"999999999999d", 0, ErrDurationTooLong},
+		{"99999999999999999999w", 0, ErrDurationTooLong},
+		{"0d", 0, ErrInvalidDuration},
+		{"-1d", 0, ErrInvalidDuration},
+		{"d", 0, ErrInvalidDuration},
+		{"spam", 0, ErrInvalidDuration},
+	} {
+		got, err := ParseDuration(test.str)
+		if got != test.want || err != test.err {
+			t.Errorf("ParseDuration(%q) = %v, %v, want %v, %v", test.str, got, err, test.want, test.err)
+		}
+	}
+}
@@ -4,15 +4,20 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dbhq/discordgo"
+	"github.com/dbhq/starboard/bot/commandler"
+	"github.com/dbhq/starboard/bot/tables"
 	"github.com/dbhq/starboard/bot/util"
+	"github.com/go-pg/pg"
+	"github.com/go-pg/pg/orm"
 )
 
 func findDefaultChannel(key string, state *discordgo.State, guild *discordgo.Guild) *discordgo.Channel {
 	for _, channel := range guild.Channels {
 		switch {
-		case channel.Type != discordgo.ChannelTypeGuildText,
+		case !isStarboardType(channel),
 			key == settingNSFWChannel && !channel.NSFW,
 			!strings.Contains(channel.Name, "starboard"):
 		default:
@@ -26,6 +31,296 @@ func findDefaultChannel(key string, state *discordgo.State, guild *discordgo.Gui
 	return nil
 }
 
+// isStarboardType checks whether a channel is of a type starboard posts can be sent to
+func isStarboardType(c *discordgo.Channel) bool {
+	return c.Type == discordgo.ChannelTypeGuildText || c.Type == discordgo.ChannelTypeGuildNews
+}
+
+// isThread checks whether a channel is a thread, which includes forum posts
+func isThread(c *discordgo.Channel) bool {
+	switch c.Type {
+	case discordgo.ChannelTypeGuildNewsThread, discordgo.ChannelTypeGuildPublicThread, discordgo.ChannelTypeGuildPrivateThread:
+		return true
+	}
+
+	return false
+}
+
+// getChannel gets a channel from the state, falling back to the API for threads it doesn't know of
+func (b *Bot) getChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	if c, err := s.State.Channel(channelID); err == nil {
+		return c, nil
+	}
+
+	key := "channels:" + channelID
+	if x, found := b.Cache.Get(key); found {
+		return x.(*discordgo.Channel), nil
+	}
+
+	c, err := s.Channel(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Cache.Set(key, c, expiryTime)
+	return c, nil
+}
+
+// sourceChannel gets the channel whose rules apply to messages sent in a channel. Threads
+// and forum posts follow their parent channel, other channels follow themselves.
+func (b *Bot) sourceChannel(s *discordgo.Session, channelID string) (*discordgo.Channel, error) {
+	c, err := b.getChannel(s, channelID)
+	if err != nil || !isThread(c) || c.ParentID == "" {
+		return c, err
+	}
+
+	return b.getChannel(s, c.ParentID)
+}
+
+// botPermissions gets the bot's permissions in a channel. Threads aren't kept in the state,
+// so the permissions of their parent channel are used instead.
+func (b *Bot) botPermissions(s *discordgo.Session, channelID string) (int, error) {
+	c, err := b.sourceChannel(s, channelID)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.State.UserChannelPermissions(s.State.User.ID, c.ID)
+}
+
+// canRead checks whether a member can read the message history of a channel in a guild.
+// Threads follow the permissions of their parent channel.
+func (b *Bot) canRead(s *discordgo.Session, guildID, channelID, userID string) bool {
+	c, err := b.sourceChannel(s, channelID)
+	if err != nil || c.GuildID != guildID {
+		return false
+	}
+
+	// Unlike guildPermissions, this applies the channel's overwrites, which is what makes channels private
+	required := discordgo.PermissionReadMessages | discordgo.PermissionReadMessageHistory
+	perms, err := s.State.UserChannelPermissions(userID, c.ID)
+	return err == nil && perms&required == required
+}
+
+// starboardChannelArg gets the first mentioned channel if messages can be posted there and replies otherwise
+func starboardChannelArg(ctx *commandler.Context, l string) *discordgo.Channel {
+	channels := ctx.MentionedChannels()
+	if len(channels) == 0 || !isStarboardType(channels[0]) {
+		ctx.Say("settings.restrictions.channel", l)
+		return nil
+	}
+
+	perms, err := ctx.Session.State.UserChannelPermissions(ctx.Session.State.User.ID, channels[0].ID)
+	if err != nil || perms&discordgo.PermissionSendMessages != discordgo.PermissionSendMessages {
+		ctx.Say("settings.restrictions.channel_perms")
+		return nil
+	}
+
+	return channels[0]
+}
+
+// parseMessageArg extracts the channel and message IDs from a message ID or link
+func parseMessageArg(ctx *commandler.Context, arg string) (channelID, messageID string, ok bool) {
+	matches := reMessageID.FindStringSubmatch(arg)
+	if matches == nil {
+		return
+	}
+
+	channelID = matches[2]
+	messageID = matches[3]
+
+	if channelID == "" {
+		channelID = ctx.ChannelID
+	}
+
+	if messageID == "" {
+		messageID = matches[1]
+	}
+
+	return channelID, messageID, true
+}
+
+// messageLink returns the jump link of a message
+func messageLink(msg *tables.Message) string {
+	return fmt.Sprintf("https://discordapp.com/channels/%s/%s/%s", msg.GuildID, msg.ChannelID, msg.ID)
+}
+
+// truncate shortens a string to at most max runes
+func truncate(str string, max int) string {
+	runes := []rune(str)
+	if len(runes) <= max {
+		return str
+	}
+
+	return string(runes[:max-1]) + "…"
+}
+
+// restErrorCode gets the Discord error code of a REST error
+func restErrorCode(err error) int {
+	if rErr, ok := err.(*discordgo.RESTError); ok && rErr.Message != nil {
+		return rErr.Message.Code
+	}
+
+	return 0
+}
+
+// starFilter narrows down the messages looked at by commands such as top
+type starFilter struct {
+	Since     time.Time
+	AuthorID  string
+	ChannelID string
+}
+
+var starPeriods = map[string]time.Duration{
+	"week":  7 * 24 * time.Hour,
+	"month": 30 * 24 * time.Hour,
+	"year":  365 * 24 * time.Hour,
+	"all":   0,
+}
+
+// mentionedUser gets the first user mentioned in a command, skipping the bot itself
+// since it's mentioned whenever the bot's mention is used as the prefix
+func mentionedUser(ctx *commandler.Context) *discordgo.User {
+	for _, u := range ctx.Mentions {
+		if u.ID != ctx.Session.State.User.ID {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// parseStarFilter parses a period, an @author and a #channel out of the arguments of a command.
+// It replies and returns false if an argument isn't any of those.
+func parseStarFilter(ctx *commandler.Context, args []string) (f starFilter, ok bool) {
+	if u := mentionedUser(ctx); u != nil {
+		f.AuthorID = u.ID
+	}
+
+	if channels := ctx.MentionedChannels(); len(channels) != 0 {
+		f.ChannelID = channels[0].ID
+	}
+
+	for _, arg := range args {
+		if reMention.MatchString(arg) {
+			continue
+		}
+
+		found := false
+		for name, d := range starPeriods {
+			if strings.ToLower(arg) != ctx.S("commands.top.phrase."+name) {
+				continue
+			}
+
+			if d != 0 {
+				f.Since = time.Now().Add(-d)
+			}
+
+			found = true
+		}
+
+		if !found {
+			ctx.SayList("settings.restrictions.one_of", ctx.S("commands.top.phrase.period"),
+				ctx.S("commands.top.phrase.week"), ctx.S("commands.top.phrase.month"), ctx.S("commands.top.phrase.year"), ctx.S("commands.top.phrase.all"))
+			return f, false
+		}
+	}
+
+	return f, true
+}
+
+// apply narrows down a query whose messages table is aliased as message. A period counts the
+// stars given during it, so it needs the reactions table of guildStars, aliased as reaction.
+func (f starFilter) apply(q *orm.Query) *orm.Query {
+	if !f.Since.IsZero() {
+		q = q.Where("reaction.created_at >= ?", f.Since)
+	}
+
+	if f.AuthorID != "" {
+		q = q.Where("message.author_id = ?", f.AuthorID)
+	}
+
+	if f.ChannelID != "" {
+		q = q.Where("message.channel_id = ?", f.ChannelID)
+	}
+
+	return q
+}
+
+// nsfwChannels gets the channels of a guild's tracked messages that are NSFW. Threads follow their
+// parent, and channels that can't be found anymore are included since their parent isn't known.
+func (b *Bot) nsfwChannels(s *discordgo.Session, guildID string) (ids []string, err error) {
+	var channels []string
+	_, err = b.PG.Query(&channels, "SELECT DISTINCT channel_id FROM messages WHERE guild_id = ?", guildID)
+	if err != nil {
+		return
+	}
+
+	for _, id := range channels {
+		if c, err := b.sourceChannel(s, id); err != nil || c.NSFW {
+			ids = append(ids, id)
+		}
+	}
+
+	return
+}
+
+// isNSFW checks whether a channel, or the parent of a thread, is NSFW
+func (b *Bot) isNSFW(s *discordgo.Session, channelID string) bool {
+	c, err := b.sourceChannel(s, channelID)
+	return err == nil && c.NSFW
+}
+
+// excludeNSFW narrows down a query whose messages table is aliased as message to SFW channels,
+// unless the command was run in an NSFW channel
+func (b *Bot) excludeNSFW(ctx *commandler.Context, q *orm.Query) (*orm.Query, error) {
+	if b.isNSFW(ctx.Session, ctx.ChannelID) {
+		return q, nil
+	}
+
+	nsfw, err := b.nsfwChannels(ctx.Session, ctx.GuildID)
+	if err != nil || len(nsfw) == 0 {
+		return q, err
+	}
+
+	return q.Where("message.channel_id NOT IN (?)", pg.In(nsfw)), nil
+}
+
+type minimumOverride struct {
+	ID      string
+	Minimum int
+}
+
+// minimumOverrides gets the channels and categories of a guild that override the minimum
+func (b *Bot) minimumOverrides(g *discordgo.Guild) (overrides []minimumOverride) {
+	if g == nil {
+		return
+	}
+
+	for _, c := range g.Channels {
+		if v, ok := b.channelMinimum(c.ID, g.ID); ok {
+			overrides = append(overrides, minimumOverride{c.ID, v})
+		}
+	}
+
+	return
+}
+
+// channelMinimum gets the minimum a channel or category overrides. The oldest channel of
+// older guilds shares the guild's ID, and with it the guild's settings, so it has none.
+func (b *Bot) channelMinimum(channelID, guildID string) (int, bool) {
+	if channelID == guildID {
+		return 0, false
+	}
+
+	v, ok := b.Settings.Lookup(channelID, settingMinimum)
+	if !ok {
+		return 0, false
+	}
+
+	return v.(int), true
+}
+
 func getSettingString(key string, value interface{}) string {
 	if strings.Contains(key, settingChannel) && value != settingNone {
 		if str, ok := value.(string); ok {
@@ -37,9 +332,161 @@ func getSettingString(key string, value interface{}) string {
 		value = util.Languages[value.(string)]
 	}
 
+	switch key {
+	case settingMaxAge, settingStarWindow, settingStarBudget, settingAntiStarRemoval:
+		if value.(int) == 0 {
+			return "∞"
+		}
+	}
+
+	switch key {
+	case settingMaxAge, settingMinAccountAge:
+		return strconv.Itoa(value.(int)) + "d"
+	case settingStarWindow, settingMinMemberAge:
+		return strconv.Itoa(value.(int)) + "h"
+	}
+
+	if emojis, ok := value.([]*util.Emoji); ok {
+		return joinEmojis(emojis)
+	}
+
+	if tiers, ok := value.([]*util.Tier); ok {
+		var sb strings.Builder
+
+		for _, tier := range tiers {
+			sb.WriteString(fmt.Sprintf("\n`%d+` #%06X", tier.Threshold, tier.Color))
+
+			if tier.Emoji != nil {
+				sb.WriteString(" " + tier.Emoji.String())
+			}
+
+			if tier.Label != "" {
+				sb.WriteString(" " + tier.Label)
+			}
+		}
+
+		return sb.String()
+	}
+
 	if key == settingRandomStarProbability {
 		return strconv.FormatFloat(value.(float64), 'f', -1, 64) + "%"
 	}
 
 	return fmt.Sprintf("%v", value)
 }
+
+// parseColor parses a hex color such as #FFAC33
+func parseColor(str string) (int, bool) {
+	str = strings.TrimPrefix(strings.TrimPrefix(strings.ToLower(str), "#"), "0x")
+
+	color, err := strconv.ParseInt(str, 16, 32)
+	if err != nil || color < 0 || color > 0xFFFFFF {
+		return 0, false
+	}
+
+	return int(color), true
+}
+
+// guildPermissions gets the permissions a member has through their roles, ignoring channel overwrites
+func guildPermissions(s *discordgo.Session, guildID, userID string) (perms int) {
+	g, err := s.State.Guild(guildID)
+	if err != nil {
+		return
+	}
+
+	if g.OwnerID == userID {
+		return discordgo.PermissionAll
+	}
+
+	member, err := s.State.Member(guildID, userID)
+	if err != nil {
+		return
+	}
+
+	for _, role := range g.Roles {
+		if role.ID == guildID || hasString(member.Roles, role.ID) {
+			perms |= role.Permissions
+		}
+	}
+
+	if perms&discordgo.PermissionAdministrator == discordgo.PermissionAdministrator {
+		return discordgo.PermissionAll
+	}
+
+	return
+}
+
+// highestRolePosition gets the position of a member's highest role
+func highestRolePosition(s *discordgo.Session, guildID, userID string) (position int) {
+	member, err := s.State.Member(guildID, userID)
+	if err != nil {
+		return
+	}
+
+	for _, id := range member.Roles {
+		if role, err := s.State.Role(guildID, id); err == nil && role.Position > position {
+			position = role.Position
+		}
+	}
+
+	return
+}
+
+func hasString(strs []string, str string) bool {
+	for _, s := range strs {
+		if s == str {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasSource(board *tables.Board, channelID string) bool {
+	if len(board.Channels) == 0 {
+		return true
+	}
+
+	for _, id := range board.Channels {
+		if id == channelID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseEmojis parses a space separated list of emojis, returning nil if any is invalid
+func parseEmojis(str string) (emojis []*util.Emoji) {
+	for _, field := range strings.Fields(str) {
+		e := util.ParseEmoji(field)
+		if e == nil {
+			return nil
+		}
+
+		if !hasEmoji(emojis, e.Key()) {
+			emojis = append(emojis, e)
+		}
+	}
+
+	return
+}
+
+func joinEmojis(emojis []*util.Emoji) string {
+	strs := make([]string, len(emojis))
+	for i, e := range emojis {
+		strs[i] = e.String()
+	}
+
+	return strings.Join(strs, " ")
+}
+
+func hasEmoji(emojis []*util.Emoji, key string) bool {
+	for _, e := range emojis {
+		if e.Key() == key {
+			return true
+		}
+	}
+
+	return false
+}
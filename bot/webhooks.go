@@ -0,0 +1,142 @@
+package bot
+
+import (
+	"github.com/dbhq/discordgo"
+	"github.com/dbhq/starboard/bot/tables"
+)
+
+const maxWebhookUsernameLength = 80
+
+// getWebhook gets the bot's webhook in a channel, creating one if needed. It
+// returns nil if the bot is missing the Manage Webhooks permission there.
+func (b *Bot) getWebhook(s *discordgo.Session, channelID string) *discordgo.Webhook {
+	key := "webhooks:" + channelID
+	if x, found := b.Cache.Get(key); found {
+		return x.(*discordgo.Webhook)
+	}
+
+	perms, err := s.State.UserChannelPermissions(s.State.User.ID, channelID)
+	if err != nil || perms&discordgo.PermissionManageWebhooks != discordgo.PermissionManageWebhooks {
+		return nil
+	}
+
+	webhooks, err := s.ChannelWebhooks(channelID)
+	if err != nil {
+		return nil
+	}
+
+	var webhook *discordgo.Webhook
+	for _, wh := range webhooks {
+		if wh.User != nil && wh.User.ID == s.State.User.ID && wh.Token != "" {
+			webhook = wh
+			break
+		}
+	}
+
+	if webhook == nil {
+		webhook, err = s.WebhookCreate(channelID, s.State.User.Username, "")
+		if err != nil {
+			return nil
+		}
+	}
+
+	b.Cache.Set(key, webhook, expiryTime)
+	return webhook
+}
+
+// sendPost posts embeds to a starboard channel, impersonating the message's
+// author through a webhook if the guild opted in and falling back to the bot
+func (b *Bot) sendPost(s *discordgo.Session, channelID string, msg *tables.Message, embeds []*discordgo.MessageEmbed) (string, error) {
+	if b.Settings.GetBool(msg.GuildID, settingWebhook) {
+		if wh := b.getWebhook(s, channelID); wh != nil {
+			sent, err := s.WebhookExecute(wh.ID, wh.Token, true, &discordgo.WebhookParams{
+				Username:  truncate(msg.Username, maxWebhookUsernameLength),
+				AvatarURL: msg.Avatar,
+				Embeds:    embeds,
+			})
+			if err == nil {
+				return sent.ID, nil
+			}
+
+			if restErrorCode(err) == discordgo.ErrCodeUnknownWebhook {
+				b.Cache.Delete("webhooks:" + channelID)
+			}
+		}
+	}
+
+	sent, err := s.ChannelMessageSendComplex(channelID, &discordgo.MessageSend{Embeds: embeds})
+	if err != nil {
+		return "", err
+	}
+
+	return sent.ID, nil
+}
+
+// editPost edits a starboard post, whether it was sent by the bot or its webhook
+func (b *Bot) editPost(s *discordgo.Session, channelID string, msg *tables.Message, sentID string, embeds []*discordgo.MessageEmbed) (err error) {
+	if b.Settings.GetBool(msg.GuildID, settingWebhook) {
+		if wh := b.getWebhook(s, channelID); wh != nil {
+			_, err = s.RequestWithBucketID(
+				"PATCH",
+				discordgo.EndpointWebhookToken(wh.ID, wh.Token)+"/messages/"+sentID,
+				struct {
+					Embeds []*discordgo.MessageEmbed `json:"embeds"`
+				}{embeds},
+				discordgo.EndpointWebhookToken(wh.ID, ""),
+			)
+			if err == nil {
+				return
+			}
+
+			if restErrorCode(err) == discordgo.ErrCodeUnknownWebhook {
+				b.Cache.Delete("webhooks:" + channelID)
+			}
+		}
+	}
+
+	_, err = s.ChannelMessageEditComplex(&discordgo.MessageEdit{
+		ID:      sentID,
+		Channel: channelID,
+		Embeds:  embeds,
+	})
+
+	// The post was sent by a webhook, so it's removed before being sent again by the bot. If
+	// it can't be removed, it's kept rather than being left next to a duplicate.
+	if restErrorCode(err) == discordgo.ErrCodeCannotEditFromAnotherUser {
+		if dErr := s.ChannelMessageDelete(channelID, sentID); dErr != nil && restErrorCode(dErr) != discordgo.ErrCodeUnknownMessage {
+			return dErr
+		}
+	}
+
+	return
+}
+
+// deletePost deletes a starboard post, whether it was sent by the bot or its webhook
+func (b *Bot) deletePost(s *discordgo.Session, channelID, guildID, sentID string) (err error) {
+	if b.Settings.GetBool(guildID, settingWebhook) {
+		if wh := b.getWebhook(s, channelID); wh != nil {
+			_, err = s.RequestWithBucketID(
+				"DELETE",
+				discordgo.EndpointWebhookToken(wh.ID, wh.Token)+"/messages/"+sentID,
+				nil,
+				discordgo.EndpointWebhookToken(wh.ID, ""),
+			)
+			if err == nil {
+				return
+			}
+		}
+	}
+
+	return s.ChannelMessageDelete(channelID, sentID)
+}
+
+// isUnknownPost checks whether a post can't be edited anymore and should be sent again. This is
+// the case when it was deleted or when it was sent by a webhook that has since been deleted.
+func isUnknownPost(err error) bool {
+	switch restErrorCode(err) {
+	case discordgo.ErrCodeUnknownMessage, discordgo.ErrCodeCannotEditFromAnotherUser:
+		return true
+	}
+
+	return false
+}